@@ -0,0 +1,162 @@
+// ABOUTME: Versioned API routes: /api/v1 keeps the original payloads, /api/v2 serves a typed schema.
+// ABOUTME: The unversioned /api routes remain as deprecated aliases of /api/v1.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// legacyAPIDeprecatedAt is when the unversioned /api routes were deprecated in favour of /api/v1
+var legacyAPIDeprecatedAt = time.Date(2026, time.October, 18, 0, 0, 0, 0, time.UTC)
+
+// registerAPI mounts the API under /api/v1 and /api/v2, and the unversioned /api aliases
+func registerAPI(router *mux.Router) {
+	v1 := router.PathPrefix("/api/v1").Subrouter()
+	registerV1(v1)
+
+	v2 := router.PathPrefix("/api/v2").Subrouter()
+	v2.HandleFunc("/metadata/{param}", v2MetadataHandler).Methods("GET")
+
+	legacy := router.PathPrefix("/api").Subrouter()
+	legacy.Use(deprecatedAPI)
+	registerV1(legacy)
+}
+
+func registerV1(router *mux.Router) {
+	router.HandleFunc("/metadata", batchHandler).Methods("GET")
+	router.HandleFunc("/metadata/{param}", handler).Methods("GET")
+	router.HandleFunc("/now", nowHandler).Methods("GET")
+	router.HandleFunc("/stations", stationsHandler).Methods("GET")
+	router.HandleFunc("/stations/{param}", stationHandler).Methods("GET")
+	router.HandleFunc("/history/{param}", historyHandler).Methods("GET")
+	router.HandleFunc("/stream/{param}", streamHandler).Methods("GET")
+}
+
+// deprecatedAPI marks a response from an unversioned /api route as deprecated (RFC 9745)
+// and links to the /api/v1 route that replaces it
+func deprecatedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := "/api/v1" + strings.TrimPrefix(r.URL.Path, "/api")
+		if r.URL.RawQuery != "" {
+			successor += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Deprecation", fmt.Sprintf("@%d", legacyAPIDeprecatedAt.Unix()))
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+		next.ServeHTTP(w, r)
+	})
+}
+
+// v2Metadata is the /api/v2/metadata payload
+type v2Metadata struct {
+	Station string       `json:"station"`
+	Now     *v2Track     `json:"now"`
+	Next    []v2Track    `json:"next"`
+	Prev    []v2Track    `json:"prev"`
+	Stale   bool         `json:"stale,omitempty"`
+	Timing  *trackTiming `json:"timing,omitempty"`
+}
+
+// v2Track is a track in the v2 schema. Times are RFC 3339.
+type v2Track struct {
+	ID         string         `json:"id,omitempty"`
+	Title      string         `json:"title"`
+	Artist     string         `json:"artist"`
+	Album      string         `json:"album,omitempty"`
+	Label      string         `json:"label,omitempty"`
+	Year       *int           `json:"year,omitempty"`
+	Performers []string       `json:"performers,omitempty"`
+	Composers  []string       `json:"composers,omitempty"`
+	Links      []externalLink `json:"links,omitempty"`
+	Cover      *v2Cover       `json:"cover,omitempty"`
+	StartTime  *time.Time     `json:"startTime,omitempty"`
+	EndTime    *time.Time     `json:"endTime,omitempty"`
+}
+
+// v2Cover links to a track's cover art
+type v2Cover struct {
+	URL string `json:"url"`
+}
+
+// v2MetadataHandler serves GET /api/v2/metadata/{param}
+func v2MetadataHandler(w http.ResponseWriter, r *http.Request) {
+	serveMetadata(w, r, func(result cacheResult, timing trackTiming) ([]byte, string, error) {
+		resp, err := newV2Metadata(mux.Vars(r)["param"], result)
+		if err != nil {
+			return nil, "", err
+		}
+		// The timing changes with every request, so the weak ETag covers the metadata only
+		untimed, err := json.Marshal(resp)
+		if err != nil {
+			return nil, "", err
+		}
+		resp.Timing = &timing
+		data, err := json.Marshal(resp)
+		if err != nil {
+			return nil, "", err
+		}
+		return data, "W/" + generateETag(untimed), nil
+	})
+}
+
+// newV2Metadata converts a cached result to the v2 schema
+func newV2Metadata(station string, result cacheResult) (v2Metadata, error) {
+	var full fullMetadataResponse
+	if err := json.Unmarshal(result.Full, &full); err != nil {
+		return v2Metadata{}, fmt.Errorf("error decoding cached payload for %s: %v", station, err)
+	}
+
+	resp := v2Metadata{
+		Station: station,
+		Next:    make([]v2Track, 0, len(full.Next)),
+		Prev:    make([]v2Track, 0, len(full.Prev)),
+		Stale:   result.Stale,
+	}
+	if full.Now != nil {
+		now := newV2Track(full.Now)
+		resp.Now = &now
+	}
+	for _, track := range full.Next {
+		resp.Next = append(resp.Next, newV2Track(track))
+	}
+	for _, track := range full.Prev {
+		resp.Prev = append(resp.Prev, newV2Track(track))
+	}
+	return resp, nil
+}
+
+func newV2Track(track *publicTrack) v2Track {
+	v2 := v2Track{
+		ID:        track.SongUUID,
+		StartTime: unixTime(track.StartTime),
+		EndTime:   unixTime(track.EndTime),
+	}
+	if track.FirstLine != nil {
+		v2.Title = track.FirstLine.Title
+	}
+	if track.SecondLine != nil {
+		v2.Artist = track.SecondLine.Title
+	}
+	if track.Song != nil {
+		v2.Album, v2.Label, v2.Year = track.Song.Album, track.Song.Label, track.Song.Year
+		v2.Performers, v2.Composers, v2.Links = track.Song.Performers, track.Song.Composers, track.Song.Links
+	}
+	if track.Visuals != nil && track.Visuals.Card.Src != "" {
+		v2.Cover = &v2Cover{URL: track.Visuals.Card.Src}
+	}
+	return v2
+}
+
+// unixTime converts optional Unix seconds to a UTC time
+func unixTime(seconds *float64) *time.Time {
+	if seconds == nil || *seconds <= 0 {
+		return nil
+	}
+	t := time.Unix(int64(*seconds), 0).UTC()
+	return &t
+}
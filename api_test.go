@@ -0,0 +1,144 @@
+// ABOUTME: Unit tests for the versioned API routes and the deprecated unversioned aliases.
+// ABOUTME: Drives the router built by registerAPI with a stubbed upstream payload.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// stubLivemeta makes fetchMetadata transform a fixed livemeta payload for every station
+func stubLivemeta(t *testing.T, payload string) {
+	t.Helper()
+	originalFetchMetadata := fetchMetadata
+	t.Cleanup(func() { fetchMetadata = originalFetchMetadata })
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		raw, err := decodeLivemeta(param, []byte(payload), decodeStrict)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(transformResponse(raw, param))
+	}
+
+	cache.Purge()
+}
+
+const versionedPayload = `{
+	"now": {
+		"firstLine": "So What", "secondLine": "Miles Davis", "cover": "kind-of-blue",
+		"startTime": 1700000000, "endTime": 1700000545, "songUuid": "song-1",
+		"song": {"id": "song-1", "year": 1959, "release": {"title": "Kind of Blue", "label": "Columbia"}}
+	},
+	"next": [{"firstLine": "Freddie Freeloader", "secondLine": "Miles Davis"}, {"firstLine": "Blue in Green", "secondLine": "Miles Davis"}],
+	"prev": [],
+	"delayToRefresh": 60000
+}`
+
+func serveAPI(method, url string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	registerAPI(router)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(method, url, nil))
+	return rr
+}
+
+func TestAPIV1KeepsShape(t *testing.T) {
+	stubLivemeta(t, versionedPayload)
+
+	rr := serveAPI("GET", "/api/v1/metadata/fip_jazz")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get("Deprecation") != "" {
+		t.Error("expected no Deprecation header on v1")
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["prev"]; ok {
+		t.Errorf("expected v1 to omit an empty prev, got %s", rr.Body.String())
+	}
+	var now map[string]interface{}
+	json.Unmarshal(fields["now"], &now)
+	if _, ok := now["song"]; ok {
+		t.Errorf("expected v1 tracks without song details, got %s", fields["now"])
+	}
+	if now["firstLine"].(map[string]interface{})["title"] != "So What" {
+		t.Errorf("expected v1 firstLine.title, got %s", fields["now"])
+	}
+}
+
+func TestAPILegacyAliasIsDeprecated(t *testing.T) {
+	stubLivemeta(t, versionedPayload)
+
+	legacy := serveAPI("GET", "/api/metadata/fip_jazz?full=1")
+	v1 := serveAPI("GET", "/api/v1/metadata/fip_jazz?full=1")
+	if legacy.Code != http.StatusOK || legacy.Body.String() != v1.Body.String() {
+		t.Errorf("expected the legacy route to answer like v1, got %d %s", legacy.Code, legacy.Body.String())
+	}
+	if got, want := legacy.Header().Get("Deprecation"), fmt.Sprintf("@%d", legacyAPIDeprecatedAt.Unix()); got != want {
+		t.Errorf("Deprecation = %q, want %q", got, want)
+	}
+	if got := legacy.Header().Get("Link"); got != `</api/v1/metadata/fip_jazz?full=1>; rel="successor-version"` {
+		t.Errorf("unexpected Link header %q", got)
+	}
+
+	if rr := serveAPI("GET", "/api/stations"); rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Link"), "</api/v1/stations>") {
+		t.Errorf("expected /api/stations to alias v1, got %d %q", rr.Code, rr.Header().Get("Link"))
+	}
+}
+
+func TestAPIV2Schema(t *testing.T) {
+	stubLivemeta(t, versionedPayload)
+
+	rr := serveAPI("GET", "/api/v2/metadata/fip_jazz")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var resp struct {
+		Station string `json:"station"`
+		Now     struct {
+			ID     string `json:"id"`
+			Title  string `json:"title"`
+			Artist string `json:"artist"`
+			Album  string `json:"album"`
+			Label  string `json:"label"`
+			Year   int    `json:"year"`
+			Cover  struct {
+				URL string `json:"url"`
+			} `json:"cover"`
+			StartTime string `json:"startTime"`
+			EndTime   string `json:"endTime"`
+		} `json:"now"`
+		Next []v2Track `json:"next"`
+		Prev []v2Track `json:"prev"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+
+	now := resp.Now
+	if resp.Station != "fip_jazz" || now.ID != "song-1" || now.Title != "So What" || now.Artist != "Miles Davis" ||
+		now.Album != "Kind of Blue" || now.Label != "Columbia" || now.Year != 1959 {
+		t.Errorf("unexpected v2 track: %s", rr.Body.String())
+	}
+	if now.Cover.URL != visualBaseURL+"/kind-of-blue" {
+		t.Errorf("unexpected cover URL %q", now.Cover.URL)
+	}
+	if now.StartTime != "2023-11-14T22:13:20Z" || now.EndTime != "2023-11-14T22:22:25Z" {
+		t.Errorf("expected RFC 3339 timings, got %q and %q", now.StartTime, now.EndTime)
+	}
+	if len(resp.Next) != 2 || resp.Next[1].Title != "Blue in Green" || resp.Prev == nil || len(resp.Prev) != 0 {
+		t.Errorf("expected full next/prev arrays, got %+v %+v", resp.Next, resp.Prev)
+	}
+}
@@ -0,0 +1,117 @@
+// ABOUTME: Batch metadata endpoint returning many stations in one response.
+// ABOUTME: Stations that fail are reported individually instead of failing the batch.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBatchStations caps how many stations one batch request may name
+var maxBatchStations = 50
+
+// batchResponse is the payload of GET /api/v1/metadata?stations=
+type batchResponse struct {
+	Stations map[string]json.RawMessage `json:"stations"` // station → v1 payload
+	Errors   map[string]problem         `json:"errors"`   // station → why it is missing from stations
+}
+
+// batchStations parses the stations parameter: comma-separated names, or "all" for every
+// known station. Names are deduplicated and sorted.
+func batchStations(param string) ([]string, error) {
+	if strings.TrimSpace(param) == "" {
+		return nil, fmt.Errorf("stations must name at least one station, or all")
+	}
+	if param == "all" {
+		return registry.Names(), nil
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range strings.Split(param, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	if len(names) > maxBatchStations {
+		return nil, fmt.Errorf("at most %d stations may be requested at once, got %d", maxBatchStations, len(names))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// batchHandler serves GET /api/v1/metadata?stations=fip,fip_rock or ?stations=all.
+// Stations are looked up concurrently through the cache. The ETag combines every member's
+// ETag and failure status, so it changes whenever any station does. HTTP caches may keep
+// the response until the first member expires, and must revalidate it if any member failed.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	names, err := batchStations(r.URL.Query().Get("stations"))
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid parameter", err.Error())
+		return
+	}
+
+	results := make([]cacheResult, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i], errs[i] = lookupCachedData(r.Context(), name)
+		}(i, name)
+	}
+	wg.Wait()
+	if r.Context().Err() != nil {
+		slog.DebugContext(r.Context(), "request abandoned", "stations", len(names))
+		return
+	}
+
+	resp := batchResponse{
+		Stations: make(map[string]json.RawMessage, len(names)),
+		Errors:   make(map[string]problem),
+	}
+	now := time.Now()
+	var expiresAt time.Time
+	var members strings.Builder
+	for i, name := range names {
+		if err := errs[i]; err != nil {
+			slog.WarnContext(r.Context(), "error fetching data", "station", name, "error", err)
+			p := problemFor(err)
+			p.Instance = strings.TrimSuffix(r.URL.Path, "/") + "/" + name
+			p.Stations = nil // listed once is enough: see /api/v1/stations
+			resp.Errors[name] = p
+			fmt.Fprintf(&members, "%s %d\n", name, p.Status)
+			expiresAt = now
+			continue
+		}
+		resp.Stations[name] = json.RawMessage(results[i].Data)
+		fmt.Fprintf(&members, "%s %s\n", name, results[i].ETag)
+		memberExpiry := newTrackTiming(results[i].Data, results[i].FetchedAt, now).cacheExpiry(results[i].ExpiresAt)
+		if expiresAt.IsZero() || memberExpiry.Before(expiresAt) {
+			expiresAt = memberExpiry
+		}
+	}
+	etag := generateETag([]byte(members.String()))
+	setCacheHeaders(w, expiresAt, now)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		metadataResponses.WithLabelValues("304").Inc()
+		return
+	}
+	metadataResponses.WithLabelValues(strconv.Itoa(http.StatusOK)).Inc()
+
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,155 @@
+// ABOUTME: Unit tests for the batch metadata endpoint.
+// ABOUTME: Covers per-station failures, the combined ETag and the all keyword.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestBatchHandler(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	var song atomic.Value
+	song.Store("a")
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		switch param {
+		case "fip_metal":
+			return nil, &upstreamError{Station: param, Kind: upstreamKindStatus, StatusCode: http.StatusInternalServerError}
+		case "nope":
+			return nil, &unknownStationError{Station: param}
+		}
+		return []byte(`{"stationName":"` + param + `","now":{"songUuid":"` + song.Load().(string) + `"}}`), nil
+	}
+	resetCache := func() {
+		cache.Purge()
+	}
+	resetCache()
+
+	get := func(url, etag string) *httptest.ResponseRecorder {
+		t.Helper()
+		router := mux.NewRouter()
+		registerAPI(router)
+		req := httptest.NewRequest("GET", url, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("/api/v1/metadata?stations=fip_rock,fip,fip_metal,nope,fip", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 despite failing members, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp struct {
+		Stations map[string]struct {
+			StationName string `json:"stationName"`
+		} `json:"stations"`
+		Errors map[string]problem `json:"errors"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(resp.Stations) != 2 || resp.Stations["fip"].StationName != "fip" || resp.Stations["fip_rock"].StationName != "fip_rock" {
+		t.Errorf("unexpected stations: %+v", resp.Stations)
+	}
+	if p := resp.Errors["fip_metal"]; p.Status != http.StatusBadGateway || p.Instance != "/api/v1/metadata/fip_metal" {
+		t.Errorf("unexpected fip_metal error: %+v", p)
+	}
+	if p := resp.Errors["nope"]; p.Status != http.StatusNotFound || p.Stations != nil {
+		t.Errorf("unexpected nope error: %+v", p)
+	}
+
+	// The combined ETag holds until a member changes
+	etag := rr.Header().Get("ETag")
+	if rr := get("/api/v1/metadata?stations=fip,fip_rock,fip_metal,nope", etag); rr.Code != http.StatusNotModified {
+		t.Errorf("expected 304 for an unchanged batch, got %d", rr.Code)
+	}
+	song.Store("b")
+	resetCache()
+	if rr := get("/api/v1/metadata?stations=fip,fip_rock,fip_metal,nope", etag); rr.Code != http.StatusOK || rr.Header().Get("ETag") == etag {
+		t.Errorf("expected a new ETag once a member changed, got %d %s", rr.Code, rr.Header().Get("ETag"))
+	}
+}
+
+func TestBatchHandlerAllAndLegacy(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"` + param + `"}`), nil
+	}
+	cache.Purge()
+
+	rr := serveAPI("GET", "/api/metadata?stations=all")
+	var resp batchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(resp.Stations) != len(registry.Names()) || len(resp.Errors) != 0 {
+		t.Errorf("expected every station, got %d stations and %v", len(resp.Stations), resp.Errors)
+	}
+	if rr.Header().Get("Deprecation") == "" {
+		t.Error("expected the unversioned batch route to be deprecated")
+	}
+}
+
+func TestBatchHandlerCacheHeaders(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return nil, &upstreamError{Station: param, Kind: upstreamKindStatus, StatusCode: http.StatusInternalServerError}
+	}
+	cache.Purge()
+
+	now := time.Now()
+	cache.Set("fip", newCachedResponse([]byte(`{"stationName":"fip"}`), now, now.Add(time.Minute)))
+	cache.Set("fip_rock", newCachedResponse([]byte(`{"stationName":"fip_rock"}`), now, now.Add(20*time.Second)))
+
+	// HTTP caches keep the batch until its first member expires
+	rr := serveAPI("GET", "/api/v1/metadata?stations=fip,fip_rock")
+	var maxAge int
+	if _, err := fmt.Sscanf(rr.Header().Get("Cache-Control"), "public, max-age=%d", &maxAge); err != nil || maxAge < 19 || maxAge > 20 {
+		t.Errorf("expected max-age from the earliest member, got %q", rr.Header().Get("Cache-Control"))
+	}
+	if rr.Header().Get("Expires") == "" {
+		t.Error("expected an Expires header")
+	}
+
+	// A failed member is not cached, so neither is the batch
+	rr = serveAPI("GET", "/api/v1/metadata?stations=fip,fip_metal")
+	if cc := rr.Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("expected no-cache with a failed member, got %q", cc)
+	}
+}
+
+func TestBatchStations(t *testing.T) {
+	if names, err := batchStations(" fip_rock , fip,,fip "); err != nil || strings.Join(names, ",") != "fip,fip_rock" {
+		t.Errorf("expected deduplicated sorted names, got %v %v", names, err)
+	}
+	if _, err := batchStations(""); err == nil {
+		t.Error("expected an error for an empty stations parameter")
+	}
+	many := make([]string, maxBatchStations+1)
+	for i := range many {
+		many[i] = fmt.Sprintf("station_%d", i)
+	}
+	if _, err := batchStations(strings.Join(many, ",")); err == nil {
+		t.Error("expected an error for too many stations")
+	}
+
+	rr := serveAPI("GET", "/api/v1/metadata")
+	if p := decodeProblem(t, rr); rr.Code != http.StatusBadRequest || !strings.Contains(p.Detail, "stations") {
+		t.Errorf("expected 400 without stations, got %d %+v", rr.Code, p)
+	}
+}
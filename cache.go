@@ -0,0 +1,238 @@
+// ABOUTME: Bounded in-memory store for transformed payloads with LRU eviction and a janitor.
+// ABOUTME: Limits entries and bytes so arbitrary keys cannot grow memory; stats are on GET /admin/cache.
+package main
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Reasons an entry leaves the cache, as labelled on fip_cache_evictions_total
+const (
+	evictLRU     = "lru"     // the cache was over its entry or byte limit
+	evictExpired = "expired" // the janitor found it too stale to ever be served
+	evictRemoved = "removed" // dropped after its refresh failed
+)
+
+var (
+	cacheMaxEntries      = 1000     // Entries kept before the least recently used is evicted
+	cacheMaxBytes        = 64 << 20 // Payload bytes kept before the least recently used is evicted
+	cacheJanitorInterval = time.Minute
+
+	// cache holds every station's payload. applyConfig rebuilds it.
+	cache metadataCache = newMetadataCache()
+)
+
+// metadataCache stores CachedResponse entries by key. Implementations are safe for concurrent use.
+type metadataCache interface {
+	// Get returns the entry for key and marks it recently used
+	Get(key string) (CachedResponse, bool)
+	// Peek returns the entry for key without affecting recency or stats
+	Peek(key string) (CachedResponse, bool)
+	// Set stores an entry, evicting others if the cache is over its limits
+	Set(key string, entry CachedResponse)
+	// Remove deletes key if its entry was cached at cachedAt, so a newer entry stored
+	// concurrently survives. It reports whether an entry was removed.
+	Remove(key string, cachedAt time.Time) bool
+	// Sweep deletes entries expired by maxStale or more and returns how many it removed
+	Sweep(now time.Time, maxStale time.Duration) int
+	// Purge deletes every entry without counting evictions
+	Purge()
+	Stats() cacheStats
+}
+
+// cacheStats is a cache's size and activity as reported by /admin/cache
+type cacheStats struct {
+	Entries    int               `json:"entries"`
+	Bytes      int64             `json:"bytes"`
+	MaxEntries int               `json:"maxEntries"`
+	MaxBytes   int64             `json:"maxBytes"`
+	Hits       uint64            `json:"hits"`
+	Misses     uint64            `json:"misses"`
+	Evictions  map[string]uint64 `json:"evictions"` // by reason
+}
+
+// newMetadataCache builds a cache from the current settings that counts its evictions in
+// fip_cache_evictions_total
+func newMetadataCache() metadataCache {
+	c := newLRUCache(cacheMaxEntries, int64(cacheMaxBytes))
+	c.onEvict = func(key, reason string) {
+		cacheEvictions.WithLabelValues(key, reason).Inc()
+	}
+	return c
+}
+
+// lruCache is a metadataCache bounded by entry count and payload bytes. When either limit
+// is exceeded the least recently used entries are evicted.
+type lruCache struct {
+	maxEntries int
+	maxBytes   int64
+	onEvict    func(key, reason string) // called with mu held; must not use the cache
+
+	mu        sync.Mutex
+	items     map[string]*list.Element
+	order     *list.List // of *lruItem, most recently used first
+	bytes     int64
+	hits      uint64
+	misses    uint64
+	evictions map[string]uint64
+}
+
+type lruItem struct {
+	key   string
+	entry CachedResponse
+	size  int64
+}
+
+func newLRUCache(maxEntries int, maxBytes int64) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		items:      make(map[string]*list.Element),
+		order:      list.New(),
+		evictions:  make(map[string]uint64),
+	}
+}
+
+// entrySize approximates the memory an entry holds: its key and payloads
+func entrySize(key string, entry CachedResponse) int64 {
+	return int64(len(key) + len(entry.Data) + len(entry.Full))
+}
+
+func (c *lruCache) Get(key string) (CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		c.misses++
+		return CachedResponse{}, false
+	}
+	c.hits++
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruItem).entry, true
+}
+
+func (c *lruCache) Peek(key string) (CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		return elem.Value.(*lruItem).entry, true
+	}
+	return CachedResponse{}, false
+}
+
+func (c *lruCache) Set(key string, entry CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	size := entrySize(key, entry)
+	if elem, ok := c.items[key]; ok {
+		item := elem.Value.(*lruItem)
+		c.bytes += size - item.size
+		item.entry, item.size = entry, size
+		c.order.MoveToFront(elem)
+	} else {
+		c.items[key] = c.order.PushFront(&lruItem{key: key, entry: entry, size: size})
+		c.bytes += size
+	}
+
+	for c.order.Len() > 0 && (c.order.Len() > c.maxEntries || c.bytes > c.maxBytes) {
+		c.evict(c.order.Back(), evictLRU)
+	}
+}
+
+func (c *lruCache) Remove(key string, cachedAt time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok || !elem.Value.(*lruItem).entry.CachedAt.Equal(cachedAt) {
+		return false
+	}
+	c.evict(elem, evictRemoved)
+	return true
+}
+
+func (c *lruCache) Sweep(now time.Time, maxStale time.Duration) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if elem.Value.(*lruItem).entry.staleFor(now) >= maxStale {
+			c.evict(elem, evictExpired)
+			removed++
+		}
+		elem = next
+	}
+	return removed
+}
+
+func (c *lruCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.bytes = 0
+}
+
+func (c *lruCache) Stats() cacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	evictions := make(map[string]uint64, len(c.evictions))
+	for reason, n := range c.evictions {
+		evictions[reason] = n
+	}
+	return cacheStats{
+		Entries:    c.order.Len(),
+		Bytes:      c.bytes,
+		MaxEntries: c.maxEntries,
+		MaxBytes:   c.maxBytes,
+		Hits:       c.hits,
+		Misses:     c.misses,
+		Evictions:  evictions,
+	}
+}
+
+// evict removes an element. Callers hold mu.
+func (c *lruCache) evict(elem *list.Element, reason string) {
+	item := c.order.Remove(elem).(*lruItem)
+	delete(c.items, item.key)
+	c.bytes -= item.size
+	c.evictions[reason]++
+	if c.onEvict != nil {
+		c.onEvict(item.key, reason)
+	}
+}
+
+// runCacheJanitor drops entries too stale to ever be served again every interval until ctx is
+// cancelled, so entries that are never requested again do not linger
+func runCacheJanitor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if removed := cache.Sweep(time.Now(), max(staleWhileRevalidate, staleIfError)); removed > 0 {
+				slog.Debug("swept expired cache entries", "removed", removed)
+			}
+		}
+	}
+}
+
+// cacheHandler serves GET /admin/cache
+func cacheHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, cache.Stats())
+}
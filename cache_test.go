@@ -0,0 +1,153 @@
+// ABOUTME: Unit tests for the bounded LRU cache, its janitor and GET /admin/cache.
+// ABOUTME: Builds standalone caches so limits can be tested without touching the global one.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newLRUCache(2, 1<<20)
+	var evicted []string
+	c.onEvict = func(key, reason string) { evicted = append(evicted, key+":"+reason) }
+
+	now := time.Now()
+	c.Set("fip", CachedResponse{Data: []byte(`{}`), CachedAt: now})
+	c.Set("fip_rock", CachedResponse{Data: []byte(`{}`), CachedAt: now})
+	// Reading fip makes fip_rock the least recently used
+	if _, ok := c.Get("fip"); !ok {
+		t.Fatal("expected fip to be cached")
+	}
+	c.Set("fip_jazz", CachedResponse{Data: []byte(`{}`), CachedAt: now})
+
+	if _, ok := c.Peek("fip_rock"); ok {
+		t.Error("expected the least recently used entry to be evicted")
+	}
+	if _, ok := c.Peek("fip"); !ok {
+		t.Error("expected the recently read entry to survive")
+	}
+	if len(evicted) != 1 || evicted[0] != "fip_rock:lru" {
+		t.Errorf("unexpected evictions %v", evicted)
+	}
+
+	// Replacing an entry does not grow the cache
+	c.Set("fip_jazz", CachedResponse{Data: []byte(`{"a":1}`), CachedAt: now})
+	if stats := c.Stats(); stats.Entries != 2 || stats.Evictions[evictLRU] != 1 {
+		t.Errorf("unexpected stats after replacing an entry: %+v", stats)
+	}
+}
+
+func TestLRUCacheByteLimit(t *testing.T) {
+	payload := []byte(strings.Repeat("x", 40))
+	c := newLRUCache(100, 100)
+
+	c.Set("a", CachedResponse{Data: payload})
+	c.Set("b", CachedResponse{Data: payload})
+	if stats := c.Stats(); stats.Entries != 2 || stats.Bytes != 82 {
+		t.Fatalf("expected two entries of 41 bytes, got %+v", stats)
+	}
+	c.Set("c", CachedResponse{Data: payload, Full: payload})
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != 81 {
+		t.Errorf("expected older entries evicted to fit the byte limit, got %+v", stats)
+	}
+
+	// An entry larger than the whole cache is not kept
+	c.Set("huge", CachedResponse{Data: []byte(strings.Repeat("x", 200))})
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("expected an oversized entry to be evicted, got %+v", stats)
+	}
+}
+
+func TestLRUCacheRemoveAndSweep(t *testing.T) {
+	c := newLRUCache(10, 1<<20)
+	now := time.Now()
+
+	c.Set("fip", CachedResponse{Data: []byte(`{}`), CachedAt: now, ExpiresAt: now.Add(time.Minute)})
+	if c.Remove("fip", now.Add(-time.Second)) {
+		t.Error("expected Remove to keep an entry cached at another time")
+	}
+	if !c.Remove("fip", now) {
+		t.Error("expected Remove to drop the entry it was given")
+	}
+
+	c.Set("fresh", CachedResponse{CachedAt: now, ExpiresAt: now.Add(time.Minute)})
+	c.Set("stale", CachedResponse{CachedAt: now, ExpiresAt: now.Add(-time.Minute)})
+	c.Set("dead", CachedResponse{CachedAt: now, ExpiresAt: now.Add(-time.Hour)})
+	if removed := c.Sweep(now, 10*time.Minute); removed != 1 {
+		t.Errorf("expected one entry swept, got %d", removed)
+	}
+	if _, ok := c.Peek("dead"); ok {
+		t.Error("expected the entry past the maximum staleness to be swept")
+	}
+	if _, ok := c.Peek("stale"); !ok {
+		t.Error("expected a recently expired entry to be kept for stale serving")
+	}
+
+	c.Get("fresh")
+	c.Get("missing")
+	stats := c.Stats()
+	if stats.Hits != 1 || stats.Misses != 1 || stats.Evictions[evictRemoved] != 1 || stats.Evictions[evictExpired] != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	c.Purge()
+	if stats := c.Stats(); stats.Entries != 0 || stats.Bytes != 0 || stats.Evictions[evictExpired] != 1 {
+		t.Errorf("expected Purge to empty the cache without counting evictions, got %+v", stats)
+	}
+}
+
+func TestCacheJanitor(t *testing.T) {
+	cache.Purge()
+	now := time.Now()
+	cache.Set("fip_world", CachedResponse{CachedAt: now, ExpiresAt: now.Add(-staleIfError - staleWhileRevalidate)})
+	before := testutil.ToFloat64(cacheEvictions.WithLabelValues("fip_world", evictExpired))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runCacheJanitor(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := cache.Peek("fip_world"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the janitor to sweep")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	if got := testutil.ToFloat64(cacheEvictions.WithLabelValues("fip_world", evictExpired)) - before; got != 1 {
+		t.Errorf("expected one expired eviction counted, got %v", got)
+	}
+}
+
+func TestCacheHandler(t *testing.T) {
+	cache.Purge()
+	cache.Set("fip", CachedResponse{Data: []byte(`{}`), CachedAt: time.Now()})
+
+	rr := httptest.NewRecorder()
+	cacheHandler(rr, httptest.NewRequest("GET", "/admin/cache", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var stats cacheStats
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Entries != 1 || stats.Bytes != 5 || stats.MaxEntries != cacheMaxEntries {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
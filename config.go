@@ -0,0 +1,428 @@
+// ABOUTME: Runtime configuration from defaults, a YAML file, FIP_* environment variables and flags.
+// ABOUTME: The validated Config is applied to the package settings at startup.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every runtime setting. Later sources override earlier ones:
+// built-in defaults, then the YAML config file, then environment variables, then flags.
+type Config struct {
+	Listen          string                   `yaml:"listen"`
+	AdminListen     string                   `yaml:"admin_listen"`
+	DrainDelay      time.Duration            `yaml:"drain_delay"`
+	ShutdownTimeout time.Duration            `yaml:"shutdown_timeout"`
+	Readiness       ReadinessConfig          `yaml:"readiness"`
+	Log             LogConfig                `yaml:"log"`
+	Cache           CacheConfig              `yaml:"cache"`
+	Upstream        UpstreamConfig           `yaml:"upstream"`
+	Polling         PollingConfig            `yaml:"polling"`
+	History         HistoryConfig            `yaml:"history"`
+	Catalog         CatalogConfig            `yaml:"catalog"`
+	Stations        map[string]stationConfig `yaml:"stations"`
+}
+
+// LogConfig controls log verbosity and output format
+type LogConfig struct {
+	Level  string `yaml:"level"`  // debug, info, warn or error
+	Format string `yaml:"format"` // text or json
+}
+
+// ReadinessConfig controls what /readyz reports
+type ReadinessConfig struct {
+	UpstreamWindow time.Duration `yaml:"upstream_window"`
+}
+
+// CacheConfig controls how long transformed payloads are served from memory. Each entry is
+// fresh until upstream's delayToRefresh or the end of its track, bounded by MinTTL and MaxTTL.
+type CacheConfig struct {
+	MinTTL               time.Duration `yaml:"min_ttl"`
+	MaxTTL               time.Duration `yaml:"max_ttl"`
+	MaxEntries           int           `yaml:"max_entries"`
+	MaxBytes             int           `yaml:"max_bytes"`
+	JanitorInterval      time.Duration `yaml:"janitor_interval"`
+	StaleWhileRevalidate time.Duration `yaml:"stale_while_revalidate"`
+	StaleIfError         time.Duration `yaml:"stale_if_error"`
+}
+
+// UpstreamConfig points at the Radio France APIs and controls how they are called
+type UpstreamConfig struct {
+	BaseURL        string        `yaml:"base_url"`
+	VisualBaseURL  string        `yaml:"visual_base_url"`
+	Timeout        time.Duration `yaml:"timeout"`
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	ReadTimeout    time.Duration `yaml:"read_timeout"`
+	Retries        int           `yaml:"retries"`
+	RetryBaseDelay time.Duration `yaml:"retry_base_delay"`
+	RetryMaxDelay  time.Duration `yaml:"retry_max_delay"`
+	Breaker        BreakerConfig `yaml:"breaker"`
+	Decoding       string        `yaml:"decoding"` // lenient or strict
+}
+
+// BreakerConfig controls the per-station circuit breakers
+type BreakerConfig struct {
+	Threshold int           `yaml:"threshold"`
+	Cooldown  time.Duration `yaml:"cooldown"`
+}
+
+// PollingConfig bounds how often the prefetcher and stream pollers refresh a station
+type PollingConfig struct {
+	MinInterval time.Duration `yaml:"min_interval"`
+	MaxInterval time.Duration `yaml:"max_interval"`
+}
+
+// HistoryConfig controls the play history recorder; an empty path disables it
+type HistoryConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// CatalogConfig points at a station catalogue JSON merged under the static stations;
+// an empty URL uses the static stations only
+type CatalogConfig struct {
+	URL             string        `yaml:"url"`
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// defaultConfig returns the settings the server ran with before configuration existed
+func defaultConfig() *Config {
+	stations := make(map[string]stationConfig, len(stationMap))
+	for name, station := range stationMap {
+		stations[name] = station
+	}
+
+	return &Config{
+		Listen:          listenAddr,
+		AdminListen:     adminListenAddr,
+		DrainDelay:      drainDelay,
+		ShutdownTimeout: shutdownTimeout,
+		Readiness: ReadinessConfig{
+			UpstreamWindow: readyUpstreamWindow,
+		},
+		Log: LogConfig{
+			Level:  logLevel,
+			Format: logFormat,
+		},
+		Cache: CacheConfig{
+			MinTTL:               cacheMinTTL,
+			MaxTTL:               cacheMaxTTL,
+			MaxEntries:           cacheMaxEntries,
+			MaxBytes:             cacheMaxBytes,
+			JanitorInterval:      cacheJanitorInterval,
+			StaleWhileRevalidate: staleWhileRevalidate,
+			StaleIfError:         staleIfError,
+		},
+		Upstream: UpstreamConfig{
+			BaseURL:        baseURL,
+			VisualBaseURL:  visualBaseURL,
+			Timeout:        upstreamTimeout,
+			ConnectTimeout: upstreamConnectTimeout,
+			ReadTimeout:    upstreamReadTimeout,
+			Retries:        upstreamRetries,
+			RetryBaseDelay: upstreamRetryBaseDelay,
+			RetryMaxDelay:  upstreamRetryMaxDelay,
+			Breaker: BreakerConfig{
+				Threshold: breakerThreshold,
+				Cooldown:  breakerCooldown,
+			},
+			Decoding: upstreamDecoding,
+		},
+		Polling: PollingConfig{
+			MinInterval: minPollInterval,
+			MaxInterval: maxPollInterval,
+		},
+		History: HistoryConfig{
+			Path:     historyPath,
+			Interval: historyInterval,
+		},
+		Catalog: CatalogConfig{
+			URL:             catalogURL,
+			RefreshInterval: catalogRefreshInterval,
+		},
+		Stations: stations,
+	}
+}
+
+// setting is a scalar option that can be overridden by an environment variable and a flag
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	apply func(c *Config, value string) error
+}
+
+func stringSetting(flag, env, usage string, field func(c *Config) *string) setting {
+	return setting{flag, env, usage, func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func durationSetting(flag, env, usage string, field func(c *Config) *time.Duration) setting {
+	return setting{flag, env, usage, func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+func intSetting(flag, env, usage string, field func(c *Config) *int) setting {
+	return setting{flag, env, usage, func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+var settings = []setting{
+	stringSetting("listen", "FIP_LISTEN", "address to listen on",
+		func(c *Config) *string { return &c.Listen }),
+	stringSetting("admin-listen", "FIP_ADMIN_LISTEN", "address the /admin endpoints listen on, kept off the public listener (empty disables them)",
+		func(c *Config) *string { return &c.AdminListen }),
+	durationSetting("drain-delay", "FIP_DRAIN_DELAY", "how long /readyz fails on shutdown before new connections are refused",
+		func(c *Config) *time.Duration { return &c.DrainDelay }),
+	durationSetting("shutdown-timeout", "FIP_SHUTDOWN_TIMEOUT", "how long in-flight requests get to drain on shutdown",
+		func(c *Config) *time.Duration { return &c.ShutdownTimeout }),
+	stringSetting("log-level", "FIP_LOG_LEVEL", "log level: debug, info, warn or error",
+		func(c *Config) *string { return &c.Log.Level }),
+	stringSetting("log-format", "FIP_LOG_FORMAT", "log format: text or json",
+		func(c *Config) *string { return &c.Log.Format }),
+	durationSetting("ready-upstream-window", "FIP_READY_UPSTREAM_WINDOW", "how recently upstream must have answered for /readyz to report it healthy",
+		func(c *Config) *time.Duration { return &c.Readiness.UpstreamWindow }),
+	durationSetting("cache-min-ttl", "FIP_CACHE_MIN_TTL", "shortest time a fetched payload is fresh",
+		func(c *Config) *time.Duration { return &c.Cache.MinTTL }),
+	durationSetting("cache-max-ttl", "FIP_CACHE_MAX_TTL", "longest time a fetched payload is fresh",
+		func(c *Config) *time.Duration { return &c.Cache.MaxTTL }),
+	intSetting("cache-max-entries", "FIP_CACHE_MAX_ENTRIES", "entries cached before the least recently used is evicted",
+		func(c *Config) *int { return &c.Cache.MaxEntries }),
+	intSetting("cache-max-bytes", "FIP_CACHE_MAX_BYTES", "payload bytes cached before the least recently used is evicted",
+		func(c *Config) *int { return &c.Cache.MaxBytes }),
+	durationSetting("cache-janitor-interval", "FIP_CACHE_JANITOR_INTERVAL", "how often entries too stale to serve are dropped",
+		func(c *Config) *time.Duration { return &c.Cache.JanitorInterval }),
+	durationSetting("stale-while-revalidate", "FIP_STALE_WHILE_REVALIDATE", "how long an expired payload is served while refreshing",
+		func(c *Config) *time.Duration { return &c.Cache.StaleWhileRevalidate }),
+	durationSetting("stale-if-error", "FIP_STALE_IF_ERROR", "maximum staleness served when upstream fails",
+		func(c *Config) *time.Duration { return &c.Cache.StaleIfError }),
+	stringSetting("upstream-base-url", "FIP_UPSTREAM_BASE_URL", "Radio France livemeta base URL",
+		func(c *Config) *string { return &c.Upstream.BaseURL }),
+	stringSetting("visual-base-url", "FIP_VISUAL_BASE_URL", "Radio France cover image base URL",
+		func(c *Config) *string { return &c.Upstream.VisualBaseURL }),
+	durationSetting("upstream-timeout", "FIP_UPSTREAM_TIMEOUT", "timeout for a single upstream request",
+		func(c *Config) *time.Duration { return &c.Upstream.Timeout }),
+	durationSetting("upstream-connect-timeout", "FIP_UPSTREAM_CONNECT_TIMEOUT", "timeout for connecting to upstream",
+		func(c *Config) *time.Duration { return &c.Upstream.ConnectTimeout }),
+	durationSetting("upstream-read-timeout", "FIP_UPSTREAM_READ_TIMEOUT", "timeout waiting for upstream response headers",
+		func(c *Config) *time.Duration { return &c.Upstream.ReadTimeout }),
+	intSetting("upstream-retries", "FIP_UPSTREAM_RETRIES", "extra attempts after a 5xx or network error",
+		func(c *Config) *int { return &c.Upstream.Retries }),
+	durationSetting("upstream-retry-base-delay", "FIP_UPSTREAM_RETRY_BASE_DELAY", "delay before the first retry",
+		func(c *Config) *time.Duration { return &c.Upstream.RetryBaseDelay }),
+	durationSetting("upstream-retry-max-delay", "FIP_UPSTREAM_RETRY_MAX_DELAY", "longest delay between retries",
+		func(c *Config) *time.Duration { return &c.Upstream.RetryMaxDelay }),
+	intSetting("breaker-threshold", "FIP_BREAKER_THRESHOLD", "consecutive failures that open a station's circuit breaker",
+		func(c *Config) *int { return &c.Upstream.Breaker.Threshold }),
+	durationSetting("breaker-cooldown", "FIP_BREAKER_COOLDOWN", "how long an open circuit breaker rejects calls",
+		func(c *Config) *time.Duration { return &c.Upstream.Breaker.Cooldown }),
+	stringSetting("upstream-decoding", "FIP_UPSTREAM_DECODING", "upstream payload decoding: lenient or strict",
+		func(c *Config) *string { return &c.Upstream.Decoding }),
+	durationSetting("min-poll-interval", "FIP_MIN_POLL_INTERVAL", "shortest interval between refreshes of a station",
+		func(c *Config) *time.Duration { return &c.Polling.MinInterval }),
+	durationSetting("max-poll-interval", "FIP_MAX_POLL_INTERVAL", "longest interval between refreshes of a station",
+		func(c *Config) *time.Duration { return &c.Polling.MaxInterval }),
+	stringSetting("history-path", "FIP_HISTORY_PATH", "play history database path (empty disables history)",
+		func(c *Config) *string { return &c.History.Path }),
+	durationSetting("history-interval", "FIP_HISTORY_INTERVAL", "how often play history samples each station",
+		func(c *Config) *time.Duration { return &c.History.Interval }),
+	stringSetting("catalog-url", "FIP_CATALOG_URL", "station catalogue JSON URL (empty uses the static stations only)",
+		func(c *Config) *string { return &c.Catalog.URL }),
+	durationSetting("catalog-refresh-interval", "FIP_CATALOG_REFRESH_INTERVAL", "how often the station catalogue is reloaded",
+		func(c *Config) *time.Duration { return &c.Catalog.RefreshInterval }),
+}
+
+// loadConfig builds the configuration from args (without the program name) and getenv.
+// The config file is named by -config or FIP_CONFIG.
+func loadConfig(args []string, getenv func(string) string) (*Config, error) {
+	fs := flag.NewFlagSet("fip-metadata", flag.ContinueOnError)
+	configPath := fs.String("config", "", "path to a YAML config file (env FIP_CONFIG)")
+	for _, s := range settings {
+		fs.String(s.flag, "", fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+
+	path := *configPath
+	if path == "" {
+		path = getenv("FIP_CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value := getenv(s.env); value != "" {
+			if err := s.apply(cfg, value); err != nil {
+				return nil, fmt.Errorf("%s: %v", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.apply(cfg, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("-%s: %v", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile overlays a YAML config file. Unknown keys are rejected so typos don't go unnoticed.
+// A stations section replaces the built-in static stations entirely.
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %v", err)
+	}
+	defer f.Close()
+
+	var stations map[string]stationConfig
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	c.Stations, stations = nil, c.Stations
+	// An empty file decodes to io.EOF and leaves the defaults in place
+	if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %v", path, err)
+	}
+	if c.Stations == nil {
+		c.Stations = stations
+	}
+	return nil
+}
+
+// validate checks the configuration and reports every problem at once
+func (c *Config) validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Listen != "", "listen address must not be empty")
+	check(c.AdminListen != c.Listen, "admin_listen must differ from listen, got %q", c.AdminListen)
+	check(c.DrainDelay >= 0, "drain_delay must not be negative, got %s", c.DrainDelay)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout must be positive, got %s", c.ShutdownTimeout)
+	check(validLogLevel(c.Log.Level), "log.level must be debug, info, warn or error, got %q", c.Log.Level)
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format must be text or json, got %q", c.Log.Format)
+	check(c.Readiness.UpstreamWindow > 0, "readiness.upstream_window must be positive, got %s", c.Readiness.UpstreamWindow)
+	check(c.Cache.MinTTL > 0, "cache.min_ttl must be positive, got %s", c.Cache.MinTTL)
+	check(c.Cache.MaxTTL >= c.Cache.MinTTL, "cache.max_ttl (%s) must not be less than cache.min_ttl (%s)", c.Cache.MaxTTL, c.Cache.MinTTL)
+	check(c.Cache.StaleWhileRevalidate >= 0, "cache.stale_while_revalidate must not be negative, got %s", c.Cache.StaleWhileRevalidate)
+	check(c.Cache.MaxEntries > 0, "cache.max_entries must be positive, got %d", c.Cache.MaxEntries)
+	check(c.Cache.MaxBytes > 0, "cache.max_bytes must be positive, got %d", c.Cache.MaxBytes)
+	check(c.Cache.JanitorInterval > 0, "cache.janitor_interval must be positive, got %s", c.Cache.JanitorInterval)
+	check(c.Cache.StaleIfError >= 0, "cache.stale_if_error must not be negative, got %s", c.Cache.StaleIfError)
+	check(isHTTPURL(c.Upstream.BaseURL), "upstream.base_url must be an absolute http(s) URL, got %q", c.Upstream.BaseURL)
+	check(isHTTPURL(c.Upstream.VisualBaseURL), "upstream.visual_base_url must be an absolute http(s) URL, got %q", c.Upstream.VisualBaseURL)
+	check(c.Upstream.Timeout > 0, "upstream.timeout must be positive, got %s", c.Upstream.Timeout)
+	check(c.Upstream.ConnectTimeout > 0, "upstream.connect_timeout must be positive, got %s", c.Upstream.ConnectTimeout)
+	check(c.Upstream.ReadTimeout > 0, "upstream.read_timeout must be positive, got %s", c.Upstream.ReadTimeout)
+	check(c.Upstream.Retries >= 0, "upstream.retries must not be negative, got %d", c.Upstream.Retries)
+	check(c.Upstream.RetryBaseDelay >= 0, "upstream.retry_base_delay must not be negative, got %s", c.Upstream.RetryBaseDelay)
+	check(c.Upstream.RetryMaxDelay >= c.Upstream.RetryBaseDelay, "upstream.retry_max_delay (%s) must not be less than upstream.retry_base_delay (%s)",
+		c.Upstream.RetryMaxDelay, c.Upstream.RetryBaseDelay)
+	check(c.Upstream.Breaker.Threshold > 0, "upstream.breaker.threshold must be positive, got %d", c.Upstream.Breaker.Threshold)
+	check(c.Upstream.Breaker.Cooldown > 0, "upstream.breaker.cooldown must be positive, got %s", c.Upstream.Breaker.Cooldown)
+	check(c.Upstream.Decoding == decodeLenient || c.Upstream.Decoding == decodeStrict, "upstream.decoding must be lenient or strict, got %q", c.Upstream.Decoding)
+	check(c.Polling.MinInterval > 0, "polling.min_interval must be positive, got %s", c.Polling.MinInterval)
+	check(c.Polling.MaxInterval >= c.Polling.MinInterval, "polling.max_interval (%s) must not be less than polling.min_interval (%s)",
+		c.Polling.MaxInterval, c.Polling.MinInterval)
+	check(c.History.Path == "" || c.History.Interval > 0, "history.interval must be positive, got %s", c.History.Interval)
+	check(c.Catalog.URL == "" || isHTTPURL(c.Catalog.URL), "catalog.url must be an absolute http(s) URL, got %q", c.Catalog.URL)
+	check(c.Catalog.URL == "" || c.Catalog.RefreshInterval > 0, "catalog.refresh_interval must be positive, got %s", c.Catalog.RefreshInterval)
+	check(len(c.Stations) > 0 || c.Catalog.URL != "", "at least one station or a catalog.url must be configured")
+	names := make([]string, 0, len(c.Stations))
+	for name := range c.Stations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		station := c.Stations[name]
+		check(name != "", "station names must not be empty")
+		check(station.ID > 0, "station %s: id must be positive, got %d", name, station.ID)
+		check(station.Format != "", "station %s: format must not be empty", name)
+	}
+
+	return errors.Join(errs...)
+}
+
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// applyConfig installs the configuration into the package settings
+func applyConfig(c *Config) {
+	listenAddr, adminListenAddr = c.Listen, c.AdminListen
+	logLevel, logFormat = c.Log.Level, c.Log.Format
+	drainDelay, shutdownTimeout = c.DrainDelay, c.ShutdownTimeout
+	readyUpstreamWindow = c.Readiness.UpstreamWindow
+	cacheMinTTL, cacheMaxTTL = c.Cache.MinTTL, c.Cache.MaxTTL
+	staleWhileRevalidate = c.Cache.StaleWhileRevalidate
+	staleIfError = c.Cache.StaleIfError
+	cacheMaxEntries, cacheMaxBytes = c.Cache.MaxEntries, c.Cache.MaxBytes
+	cacheJanitorInterval = c.Cache.JanitorInterval
+	baseURL = c.Upstream.BaseURL
+	visualBaseURL = c.Upstream.VisualBaseURL
+	upstreamTimeout = c.Upstream.Timeout
+	upstreamConnectTimeout = c.Upstream.ConnectTimeout
+	upstreamReadTimeout = c.Upstream.ReadTimeout
+	upstreamRetries = c.Upstream.Retries
+	upstreamRetryBaseDelay = c.Upstream.RetryBaseDelay
+	upstreamRetryMaxDelay = c.Upstream.RetryMaxDelay
+	breakerThreshold = c.Upstream.Breaker.Threshold
+	breakerCooldown = c.Upstream.Breaker.Cooldown
+	upstreamDecoding = c.Upstream.Decoding
+	minPollInterval = c.Polling.MinInterval
+	maxPollInterval = c.Polling.MaxInterval
+	historyPath = c.History.Path
+	historyInterval = c.History.Interval
+	catalogURL = c.Catalog.URL
+	catalogRefreshInterval = c.Catalog.RefreshInterval
+	stationMap = c.Stations
+	registry.SetStatic(c.Stations)
+	upstream = newUpstreamClient()
+	cache = newMetadataCache()
+}
@@ -0,0 +1,190 @@
+// ABOUTME: Unit tests for configuration loading, precedence and validation.
+// ABOUTME: Uses temporary YAML files and a fake environment.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEnv returns a getenv function backed by a map
+func fakeEnv(vars map[string]string) func(string) string {
+	return func(key string) string { return vars[key] }
+}
+
+func writeConfigFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	cfg, err := loadConfig(nil, fakeEnv(nil))
+	if err != nil {
+		t.Fatalf("loadConfig returned an error: %v", err)
+	}
+
+	if cfg.Listen != ":8080" {
+		t.Errorf("expected default listen :8080, got %s", cfg.Listen)
+	}
+	if cfg.Upstream.BaseURL != "https://api.radiofrance.fr/livemeta/live" {
+		t.Errorf("unexpected default base URL: %s", cfg.Upstream.BaseURL)
+	}
+	if len(cfg.Stations) != len(stationMap) {
+		t.Errorf("expected %d default stations, got %d", len(stationMap), len(cfg.Stations))
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	path := writeConfigFile(t, `
+listen: ":9000"
+cache:
+  min_ttl: 5s
+upstream:
+  base_url: http://localhost:4000/livemeta/live
+  timeout: 3s
+stations:
+  fip:
+    id: 7
+    format: webrf_fip_player
+`)
+
+	env := fakeEnv(map[string]string{
+		"FIP_CONFIG":           path,
+		"FIP_CACHE_MIN_TTL":    "7s",
+		"FIP_UPSTREAM_TIMEOUT": "4s",
+	})
+	cfg, err := loadConfig([]string{"-upstream-timeout", "2s"}, env)
+	if err != nil {
+		t.Fatalf("loadConfig returned an error: %v", err)
+	}
+
+	if cfg.Listen != ":9000" {
+		t.Errorf("expected listen from file, got %s", cfg.Listen)
+	}
+	if cfg.Upstream.BaseURL != "http://localhost:4000/livemeta/live" {
+		t.Errorf("expected base URL from file, got %s", cfg.Upstream.BaseURL)
+	}
+	if cfg.Cache.MinTTL != 7*time.Second {
+		t.Errorf("expected env to override file TTL, got %s", cfg.Cache.MinTTL)
+	}
+	if cfg.Upstream.Timeout != 2*time.Second {
+		t.Errorf("expected flag to override env timeout, got %s", cfg.Upstream.Timeout)
+	}
+	if cfg.Cache.StaleIfError != staleIfError {
+		t.Errorf("expected unset keys to keep defaults, got %s", cfg.Cache.StaleIfError)
+	}
+	if len(cfg.Stations) != 1 || cfg.Stations["fip"].ID != 7 {
+		t.Errorf("expected file stations to replace defaults, got %v", cfg.Stations)
+	}
+}
+
+func TestLoadConfigFileFlagWinsOverEnv(t *testing.T) {
+	fromFlag := writeConfigFile(t, `listen: ":9001"`)
+	fromEnv := writeConfigFile(t, `listen: ":9002"`)
+
+	cfg, err := loadConfig([]string{"-config", fromFlag}, fakeEnv(map[string]string{"FIP_CONFIG": fromEnv}))
+	if err != nil {
+		t.Fatalf("loadConfig returned an error: %v", err)
+	}
+	if cfg.Listen != ":9001" {
+		t.Errorf("expected -config to take precedence over FIP_CONFIG, got %s", cfg.Listen)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		args    []string
+		wantErr string
+	}{
+		{"missing file", "", map[string]string{"FIP_CONFIG": "/nonexistent/config.yaml"}, nil, "error opening config file"},
+		{"unknown key", "cache:\n  tll: 5s\n", nil, nil, "field tll not found"},
+		{"bad env duration", "", map[string]string{"FIP_CACHE_MIN_TTL": "soon"}, nil, `FIP_CACHE_MIN_TTL: invalid duration "soon"`},
+		{"bad flag duration", "", nil, []string{"-upstream-timeout", "x"}, `-upstream-timeout: invalid duration "x"`},
+		{"bad env integer", "", map[string]string{"FIP_UPSTREAM_RETRIES": "many"}, nil, `FIP_UPSTREAM_RETRIES: invalid integer "many"`},
+		{"zero breaker threshold", "upstream:\n  breaker:\n    threshold: 0\n", nil, nil, "upstream.breaker.threshold must be positive"},
+		{"bad log level", "", map[string]string{"FIP_LOG_LEVEL": "loud"}, nil, `log.level must be debug, info, warn or error, got "loud"`},
+		{"bad log format", "log:\n  format: xml\n", nil, nil, `log.format must be text or json, got "xml"`},
+		{"bad decoding", "upstream:\n  decoding: loose\n", nil, nil, `upstream.decoding must be lenient or strict, got "loose"`},
+		{"unknown flag", "", nil, []string{"-verbose"}, "flag provided but not defined"},
+		{"bad base URL", "", map[string]string{"FIP_UPSTREAM_BASE_URL": "localhost:4000"}, nil, "upstream.base_url must be an absolute http(s) URL"},
+		{"zero ttl", "cache:\n  min_ttl: 0s\n", nil, nil, "cache.min_ttl must be positive"},
+		{"zero max entries", "", map[string]string{"FIP_CACHE_MAX_ENTRIES": "0"}, nil, "cache.max_entries must be positive"},
+		{"max ttl below min", "cache:\n  min_ttl: 1m\n  max_ttl: 30s\n", nil, nil, "cache.max_ttl (30s) must not be less than cache.min_ttl (1m0s)"},
+		{"admin on the public listener", "", nil, []string{"-listen", ":9090", "-admin-listen", ":9090"}, "admin_listen must differ from listen"},
+		{"inverted polling", "polling:\n  min_interval: 1m\n  max_interval: 10s\n", nil, nil, "polling.max_interval (10s) must not be less than"},
+		{"empty stations", "stations: {}\n", nil, nil, "at least one station or a catalog.url must be configured"},
+		{"bad catalog URL", "catalog:\n  url: stations.json\n", nil, nil, "catalog.url must be an absolute http(s) URL"},
+		{"invalid station", "stations:\n  fip_rock:\n    id: 0\n", nil, nil, "station fip_rock: id must be positive"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			env := map[string]string{}
+			for k, v := range tc.env {
+				env[k] = v
+			}
+			if tc.file != "" {
+				env["FIP_CONFIG"] = writeConfigFile(t, tc.file)
+			}
+
+			_, err := loadConfig(tc.args, fakeEnv(env))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Listen = ""
+	cfg.Upstream.Timeout = 0
+
+	err := cfg.validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"listen address must not be empty", "upstream.timeout must be positive"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in %v", want, err)
+		}
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	originalListen, originalTTL, originalBaseURL, originalStations := listenAddr, cacheMinTTL, baseURL, stationMap
+	defer func() {
+		listenAddr, cacheMinTTL, baseURL, stationMap = originalListen, originalTTL, originalBaseURL, originalStations
+		registry.SetStatic(originalStations)
+	}()
+
+	cfg := defaultConfig()
+	cfg.Listen = ":9999"
+	cfg.Cache.MinTTL = 42 * time.Second
+	cfg.Upstream.BaseURL = "http://mock.local/livemeta/live"
+	cfg.Stations = map[string]stationConfig{"fip": {ID: 7, Format: "webrf_fip_player"}}
+	applyConfig(cfg)
+
+	if listenAddr != ":9999" || cacheMinTTL != 42*time.Second || baseURL != "http://mock.local/livemeta/live" {
+		t.Errorf("settings not applied: listen=%s ttl=%s baseURL=%s", listenAddr, cacheMinTTL, baseURL)
+	}
+	if _, ok := stationMap["fip_rock"]; ok || len(stationMap) != 1 {
+		t.Errorf("expected station list to be replaced, got %v", stationMap)
+	}
+	if _, ok := registry.Lookup("fip_rock"); ok {
+		t.Error("expected registry static stations to be replaced")
+	}
+}
@@ -0,0 +1,143 @@
+// ABOUTME: Typed errors for unknown stations and upstream failures, and their HTTP mapping.
+// ABOUTME: Errors are written as RFC 7807 application/problem+json bodies.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// Problem type URIs. Generic errors use about:blank, where the title is the HTTP status text.
+const (
+	problemTypeDefault           = "about:blank"
+	problemTypeUnknownStation    = "/problems/unknown-station"
+	problemTypeUpstreamTimeout   = "/problems/upstream-timeout"
+	problemTypeUpstreamStatus    = "/problems/upstream-status"
+	problemTypeUpstreamMalformed = "/problems/upstream-malformed"
+	problemTypeUpstreamFailed    = "/problems/upstream-unavailable"
+	problemTypeCircuitOpen       = "/problems/circuit-open"
+)
+
+// unknownStationError reports a station name that is neither configured nor in the catalogue
+type unknownStationError struct {
+	Station string
+}
+
+func (e *unknownStationError) Error() string {
+	return fmt.Sprintf("unknown station: %s", e.Station)
+}
+
+// upstreamErrorKind classifies why an upstream call failed
+type upstreamErrorKind int
+
+const (
+	upstreamKindUnavailable upstreamErrorKind = iota // network error before a response arrived
+	upstreamKindTimeout                              // no complete response within upstreamTimeout
+	upstreamKindStatus                               // non-200 response
+	upstreamKindMalformed                            // response body could not be decoded
+	upstreamKindCircuitOpen                          // not attempted: the station's circuit breaker is open
+)
+
+// upstreamError is a failed call to the Radio France API for a station
+type upstreamError struct {
+	Station    string
+	Kind       upstreamErrorKind
+	StatusCode int // set for upstreamStatus
+	Err        error
+}
+
+func (e *upstreamError) Error() string {
+	switch e.Kind {
+	case upstreamKindTimeout:
+		return fmt.Sprintf("timed out fetching data for %s: %v", e.Station, e.Err)
+	case upstreamKindStatus:
+		return fmt.Sprintf("received non-200 response code for %s: %d", e.Station, e.StatusCode)
+	case upstreamKindMalformed:
+		return fmt.Sprintf("malformed response from FIP API for %s: %v", e.Station, e.Err)
+	case upstreamKindCircuitOpen:
+		return fmt.Sprintf("not fetching data for %s: %v", e.Station, e.Err)
+	default:
+		return fmt.Sprintf("error fetching data for %s: %v", e.Station, e.Err)
+	}
+}
+
+func (e *upstreamError) Unwrap() error {
+	return e.Err
+}
+
+// newTransportError classifies an error from sending a request or reading its body
+func newTransportError(station string, err error) *upstreamError {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return &upstreamError{Station: station, Kind: upstreamKindTimeout, Err: err}
+	}
+	return &upstreamError{Station: station, Kind: upstreamKindUnavailable, Err: err}
+}
+
+// problem is an RFC 7807 problem details body
+type problem struct {
+	Type     string   `json:"type"`
+	Title    string   `json:"title"`
+	Status   int      `json:"status"`
+	Detail   string   `json:"detail,omitempty"`
+	Instance string   `json:"instance,omitempty"`
+	Stations []string `json:"stations,omitempty"` // valid station names, for unknown stations
+}
+
+// problemFor maps an error to its HTTP status and problem body. Unrecognised errors are 500s.
+func problemFor(err error) problem {
+	var unknown *unknownStationError
+	if errors.As(err, &unknown) {
+		return problem{
+			Type:     problemTypeUnknownStation,
+			Title:    "Unknown station",
+			Status:   http.StatusNotFound,
+			Detail:   unknown.Error(),
+			Stations: registry.Names(),
+		}
+	}
+
+	var upstream *upstreamError
+	if errors.As(err, &upstream) {
+		p := problem{Status: http.StatusBadGateway, Detail: upstream.Error()}
+		switch upstream.Kind {
+		case upstreamKindTimeout:
+			p.Type, p.Title, p.Status = problemTypeUpstreamTimeout, "Upstream timeout", http.StatusGatewayTimeout
+		case upstreamKindStatus:
+			p.Type, p.Title = problemTypeUpstreamStatus, "Upstream error"
+		case upstreamKindMalformed:
+			p.Type, p.Title = problemTypeUpstreamMalformed, "Malformed upstream response"
+		case upstreamKindCircuitOpen:
+			p.Type, p.Title, p.Status = problemTypeCircuitOpen, "Upstream circuit open", http.StatusServiceUnavailable
+		default:
+			p.Type, p.Title = problemTypeUpstreamFailed, "Upstream unavailable"
+		}
+		return p
+	}
+
+	return problem{
+		Type:   problemTypeDefault,
+		Title:  http.StatusText(http.StatusInternalServerError),
+		Status: http.StatusInternalServerError,
+		Detail: err.Error(),
+	}
+}
+
+// writeError writes err as a problem+json response for the request and returns the status code
+func writeError(w http.ResponseWriter, r *http.Request, err error) int {
+	p := problemFor(err)
+	p.Instance = r.URL.Path
+	writeProblemBody(w, p)
+	return p.Status
+}
+
+// writeProblem writes a generic problem+json response
+func writeProblem(w http.ResponseWriter, status int, title, detail string) {
+	writeProblemBody(w, problem{Type: problemTypeDefault, Title: title, Status: status, Detail: detail})
+}
+
+func writeProblemBody(w http.ResponseWriter, p problem) {
+	writeJSONAs(w, p.Status, "application/problem+json", p)
+}
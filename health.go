@@ -0,0 +1,90 @@
+// ABOUTME: Liveness and readiness endpoints for the platform's health checks.
+// ABOUTME: /readyz checks shutdown, the station catalogue and the cache, and reports upstream contact.
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	readyUpstreamWindow = 2 * time.Minute // /readyz reports upstream as failing when it has not answered within this long
+
+	startedAt = time.Now()
+)
+
+// readinessCheck is one condition reported by /readyz. Advisory checks are reported but do
+// not fail readiness.
+type readinessCheck struct {
+	OK       bool   `json:"ok"`
+	Detail   string `json:"detail"`
+	Advisory bool   `json:"advisory,omitempty"`
+}
+
+// healthzHandler serves GET /healthz: the process is up and serving HTTP
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":        "ok",
+		"uptimeSeconds": int64(time.Since(startedAt).Seconds()),
+	})
+}
+
+// readyzHandler serves GET /readyz: 200 when the instance should receive traffic, 503 otherwise.
+// Every check is reported so a failing probe explains itself.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := readinessChecks(time.Now())
+
+	ready := true
+	for _, check := range checks {
+		ready = ready && (check.OK || check.Advisory)
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	writeJSON(w, code, map[string]interface{}{
+		"status": status,
+		"checks": checks,
+	})
+}
+
+func readinessChecks(now time.Time) map[string]readinessCheck {
+	checks := make(map[string]readinessCheck)
+
+	if drainCtx.Err() != nil {
+		checks["shutdown"] = readinessCheck{OK: false, Detail: "draining connections"}
+	} else {
+		checks["shutdown"] = readinessCheck{OK: true, Detail: "serving"}
+	}
+
+	if catalogURL == "" {
+		checks["catalog"] = readinessCheck{OK: true, Detail: "no catalogue configured"}
+	} else if updatedAt := registry.UpdatedAt(); updatedAt.IsZero() {
+		checks["catalog"] = readinessCheck{OK: false, Detail: "catalogue not loaded yet"}
+	} else {
+		checks["catalog"] = readinessCheck{OK: true, Detail: fmt.Sprintf("loaded at %s", updatedAt.UTC().Format(time.RFC3339))}
+	}
+
+	// An upstream outage hits every instance at once, and they should keep serving stale
+	// entries through it rather than all be taken out of rotation
+	if last := upstream.LastSuccess(); last.IsZero() {
+		checks["upstream"] = readinessCheck{OK: false, Advisory: true, Detail: "no successful upstream call yet"}
+	} else if age := now.Sub(last); age > readyUpstreamWindow {
+		checks["upstream"] = readinessCheck{OK: false, Advisory: true, Detail: fmt.Sprintf("last successful upstream call %s ago", age.Round(time.Second))}
+	} else {
+		checks["upstream"] = readinessCheck{OK: true, Advisory: true, Detail: fmt.Sprintf("last successful upstream call %s ago", age.Round(time.Second))}
+	}
+
+	names := registry.Names()
+	warm := 0
+	for _, name := range names {
+		if _, ok := peekCachedData(name); ok {
+			warm++
+		}
+	}
+	checks["cache"] = readinessCheck{OK: warm > 0, Detail: fmt.Sprintf("%d of %d stations cached", warm, len(names))}
+
+	return checks
+}
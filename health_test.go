@@ -0,0 +1,220 @@
+// ABOUTME: Unit tests for /healthz, /readyz and graceful shutdown.
+// ABOUTME: Swaps the server lifecycle contexts so shutdown can be exercised per test.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// swapLifecycle gives the test its own drain and server contexts
+func swapLifecycle(t *testing.T) {
+	t.Helper()
+	originalDrain, originalStartDrain := drainCtx, startDrain
+	originalServer, originalCancelServer := serverCtx, cancelServerCtx
+	t.Cleanup(func() {
+		startDrain()
+		cancelServerCtx()
+		drainCtx, startDrain = originalDrain, originalStartDrain
+		serverCtx, cancelServerCtx = originalServer, originalCancelServer
+	})
+	drainCtx, startDrain = context.WithCancel(context.Background())
+	serverCtx, cancelServerCtx = context.WithCancel(context.Background())
+}
+
+func TestHealthzHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	healthzHandler(rr, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"status":"ok"`) {
+		t.Errorf("unexpected healthz response: %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	swapLifecycle(t)
+	originalUpstream, originalCatalogURL, originalRegistry := upstream, catalogURL, registry
+	defer func() { upstream, catalogURL, registry = originalUpstream, originalCatalogURL, originalRegistry }()
+
+	cache.Purge()
+	upstream = newUpstreamClient()
+	catalogURL = ""
+	registry = newStationRegistry(map[string]stationConfig{"fip": {ID: 7, Format: "webrf_fip_player"}})
+
+	readyz := func() (int, map[string]readinessCheck) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		readyzHandler(rr, httptest.NewRequest("GET", "/readyz", nil))
+		var resp struct {
+			Checks map[string]readinessCheck `json:"checks"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		return rr.Code, resp.Checks
+	}
+
+	// Cold start: nothing fetched yet
+	code, checks := readyz()
+	if code != http.StatusServiceUnavailable || checks["upstream"].OK || checks["cache"].OK || !checks["catalog"].OK {
+		t.Errorf("expected cold instance to be unready, got %d %+v", code, checks)
+	}
+
+	cache.Set("fip", CachedResponse{Data: []byte(`{}`), CachedAt: time.Now()})
+	if code, checks := readyz(); code != http.StatusOK {
+		t.Errorf("expected warm instance to be ready, got %d %+v", code, checks)
+	}
+
+	// A configured catalogue must have loaded
+	catalogURL = "http://catalog.local/stations.json"
+	if code, checks := readyz(); code != http.StatusServiceUnavailable || checks["catalog"].OK {
+		t.Errorf("expected unloaded catalogue to fail readiness, got %d %+v", code, checks)
+	}
+	catalogURL = ""
+
+	// Upstream silent for too long is reported, but stale entries keep the instance in rotation
+	upstream.lastSuccess.Store(time.Now().Add(-readyUpstreamWindow - time.Second).UnixNano())
+	if code, checks := readyz(); code != http.StatusOK || checks["upstream"].OK || !checks["upstream"].Advisory {
+		t.Errorf("expected stale upstream to be reported without failing readiness, got %d %+v", code, checks)
+	}
+	upstream.lastSuccess.Store(time.Now().UnixNano())
+
+	// Draining instances stop taking traffic
+	startDrain()
+	if code, checks := readyz(); code != http.StatusServiceUnavailable || checks["shutdown"].OK {
+		t.Errorf("expected draining instance to be unready, got %d %+v", code, checks)
+	}
+}
+
+func TestShutdownDrainsRequestsAndStreams(t *testing.T) {
+	swapLifecycle(t)
+	stubTrackSequence(t)
+	originalTimeout, originalDelay := shutdownTimeout, drainDelay
+	defer func() { shutdownTimeout, drainDelay = originalTimeout, originalDelay }()
+	shutdownTimeout, drainDelay = 5*time.Second, 500*time.Millisecond
+
+	inFlight, release := make(chan struct{}), make(chan struct{})
+	router := mux.NewRouter()
+	router.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		close(inFlight)
+		<-release
+		w.Write([]byte("done"))
+	})
+	router.HandleFunc("/api/stream/{param}", streamHandler)
+	router.HandleFunc("/ws", wsHandler)
+	router.HandleFunc("/readyz", readyzHandler)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: router, BaseContext: func(net.Listener) context.Context { return serverCtx }}
+	go server.Serve(listener)
+	base := "http://" + listener.Addr().String()
+
+	// An open SSE stream
+	stream, err := http.Get(base + "/api/stream/fip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	streamClosed := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+		}
+		close(streamClosed)
+	}()
+
+	// An open WebSocket
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+listener.Addr().String()+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	wsClosed := make(chan error, 1)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				wsClosed <- err
+				return
+			}
+		}
+	}()
+
+	// A request in flight
+	slow := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(base + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body := make([]byte, 4)
+		resp.Body.Read(body)
+		slow <- string(body)
+	}()
+	<-inFlight
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(server)
+		close(done)
+	}()
+
+	waitFor := func(ch <-chan struct{}, what string) {
+		t.Helper()
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	waitFor(streamClosed, "the SSE stream to close")
+	select {
+	case err := <-wsClosed:
+		if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+			t.Errorf("expected a going-away close frame, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the WebSocket to close")
+	}
+
+	// During the drain delay the listener stays open so load balancers can see /readyz fail
+	ready, err := http.Get(base + "/readyz")
+	if err != nil {
+		t.Fatalf("expected /readyz to be served during the drain delay: %v", err)
+	}
+	ready.Body.Close()
+	if ready.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to fail while draining, got %d", ready.StatusCode)
+	}
+
+	// Shutdown waits for the in-flight request
+	select {
+	case <-done:
+		t.Fatal("shutdown returned before the in-flight request finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if got := <-slow; got != "done" {
+		t.Errorf("expected in-flight request to complete, got %q", got)
+	}
+	waitFor(done, "shutdown to finish")
+
+	if serverCtx.Err() == nil {
+		t.Error("expected shutdown to cancel the server context")
+	}
+	streams.Wait()
+}
@@ -0,0 +1,302 @@
+// ABOUTME: Persistent play history for every FIP station.
+// ABOUTME: A background recorder stores each distinct "now" track in an embedded bbolt database.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+var (
+	historyPath     = "history.db"
+	historyInterval = 30 * time.Second // How often the recorder samples each station
+
+	// history is the open play store; nil when the database could not be opened
+	history *historyStore
+)
+
+// Play is a single recorded track on a station
+type Play struct {
+	SongUUID   string          `json:"songUuid"`
+	StartTime  int64           `json:"startTime"`
+	EndTime    int64           `json:"endTime,omitempty"`
+	RecordedAt time.Time       `json:"recordedAt"`
+	Track      json.RawMessage `json:"track"`
+}
+
+// historyStore persists plays in one bucket per station, keyed by start time then song UUID
+// so that a cursor walks plays in chronological order.
+type historyStore struct {
+	db *bolt.DB
+}
+
+func openHistoryStore(path string) (*historyStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("error opening history database %s: %v", path, err)
+	}
+	return &historyStore{db: db}, nil
+}
+
+func (s *historyStore) Close() error {
+	return s.db.Close()
+}
+
+// playKey builds the bucket key for a play: 8 bytes of big-endian start time followed by the song UUID.
+func playKey(startTime int64, songUUID string) []byte {
+	key := make([]byte, 8, 8+len(songUUID))
+	binary.BigEndian.PutUint64(key, uint64(startTime))
+	return append(key, songUUID...)
+}
+
+// Record stores a play for a station. It reports whether the play was new.
+func (s *historyStore) Record(station string, play Play) (bool, error) {
+	value, err := json.Marshal(play)
+	if err != nil {
+		return false, fmt.Errorf("error marshalling play for %s: %v", station, err)
+	}
+
+	inserted := false
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(station))
+		if err != nil {
+			return err
+		}
+		key := playKey(play.StartTime, play.SongUUID)
+		if bucket.Get(key) != nil {
+			return nil
+		}
+		inserted = true
+		return bucket.Put(key, value)
+	})
+	if err != nil {
+		return false, fmt.Errorf("error recording play for %s: %v", station, err)
+	}
+	return inserted, nil
+}
+
+// Query returns plays for a station whose start time lies within [from, to], newest first.
+// A non-nil before only returns plays whose key sorts before it, so paging resumes exactly
+// after the last play returned even when several plays share its start time.
+// At most limit plays are returned; the boolean reports whether older plays remain in range.
+func (s *historyStore) Query(station string, from, to int64, before []byte, limit int) ([]Play, bool, error) {
+	plays := []Play{}
+	hasMore := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(station))
+		if bucket == nil {
+			return nil
+		}
+
+		// bound is the first key past the range, or nil when the range is open-ended
+		var bound []byte
+		if to != math.MaxInt64 {
+			bound = playKey(to+1, "")
+		}
+		if before != nil && (bound == nil || bytes.Compare(before, bound) < 0) {
+			bound = before
+		}
+
+		c := bucket.Cursor()
+		var k, v []byte
+		if bound == nil {
+			k, v = c.Last()
+		} else {
+			// Seek lands on the first play past the range; step back from there
+			if k, _ = c.Seek(bound); k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			if int64(binary.BigEndian.Uint64(k[:8])) < from {
+				break
+			}
+			if len(plays) == limit {
+				hasMore = true
+				break
+			}
+			var play Play
+			if err := json.Unmarshal(v, &play); err != nil {
+				return fmt.Errorf("error decoding play %x: %v", k, err)
+			}
+			plays = append(plays, play)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("error querying history for %s: %v", station, err)
+	}
+	return plays, hasMore, nil
+}
+
+// historyRecorder samples every station on an interval and records the current track.
+type historyRecorder struct {
+	store    *historyStore
+	interval time.Duration
+}
+
+// Run records all stations immediately and then on every tick until ctx is cancelled.
+func (r *historyRecorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		for _, station := range registry.Names() {
+			if err := r.recordStation(ctx, station); err != nil {
+				slog.ErrorContext(ctx, "error recording history", "station", station, "error", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// recordStation stores the station's current track if it has not been seen before.
+func (r *historyRecorder) recordStation(ctx context.Context, station string) error {
+	data, _, err := getCachedData(ctx, station)
+	if err != nil {
+		return err
+	}
+
+	var payload struct {
+		Now json.RawMessage `json:"now"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return fmt.Errorf("error decoding cached payload: %v", err)
+	}
+	if len(payload.Now) == 0 {
+		return nil
+	}
+
+	var now struct {
+		SongUUID  string  `json:"songUuid"`
+		StartTime float64 `json:"startTime"`
+		EndTime   float64 `json:"endTime"`
+	}
+	if err := json.Unmarshal(payload.Now, &now); err != nil {
+		return fmt.Errorf("error decoding current track: %v", err)
+	}
+	// Programme segments and jingles carry no song identity; only songs are recorded
+	if now.SongUUID == "" || now.StartTime == 0 {
+		return nil
+	}
+
+	inserted, err := r.store.Record(station, Play{
+		SongUUID:   now.SongUUID,
+		StartTime:  int64(now.StartTime),
+		EndTime:    int64(now.EndTime),
+		RecordedAt: time.Now().UTC(),
+		Track:      payload.Now,
+	})
+	if err != nil {
+		return err
+	}
+	if inserted {
+		slog.InfoContext(ctx, "recorded play", "station", station, "song_uuid", now.SongUUID)
+	}
+	return nil
+}
+
+// historyHandler serves GET /api/history/{param}?from=&to=&limit=&cursor=.
+// from and to are inclusive Unix timestamps on the track start time; pages are fetched
+// by passing the returned cursor as the next request's cursor.
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	station := mux.Vars(r)["param"]
+	if _, ok := registry.Lookup(station); !ok {
+		writeError(w, r, &unknownStationError{Station: station})
+		return
+	}
+	if history == nil {
+		writeProblem(w, http.StatusServiceUnavailable, "History unavailable", "play history is not enabled on this server")
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := parseInt64Param(query.Get("from"), 0)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid parameter", fmt.Sprintf("from: %v", err))
+		return
+	}
+	to, err := parseInt64Param(query.Get("to"), math.MaxInt64)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid parameter", fmt.Sprintf("to: %v", err))
+		return
+	}
+	limit64, err := parseInt64Param(query.Get("limit"), defaultHistoryLimit)
+	if err != nil || limit64 < 1 || limit64 > maxHistoryLimit {
+		writeProblem(w, http.StatusBadRequest, "Invalid parameter",
+			fmt.Sprintf("limit must be between 1 and %d", maxHistoryLimit))
+		return
+	}
+	if from < 0 || to < 0 {
+		writeProblem(w, http.StatusBadRequest, "Invalid parameter", "from and to must not be negative")
+		return
+	}
+	if from > to {
+		writeProblem(w, http.StatusBadRequest, "Invalid parameter", "from must not be after to")
+		return
+	}
+	var before []byte
+	if cursor := query.Get("cursor"); cursor != "" {
+		if before, err = base64.RawURLEncoding.DecodeString(cursor); err != nil || len(before) < 8 {
+			writeProblem(w, http.StatusBadRequest, "Invalid parameter", "cursor must be a value returned by a previous page")
+			return
+		}
+	}
+
+	plays, hasMore, err := history.Query(station, from, to, before, int(limit64))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error querying history", "station", station, "error", err)
+		writeProblem(w, http.StatusInternalServerError, "History Error", err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"stationName": station,
+		"plays":       plays,
+		"hasMore":     hasMore,
+	}
+	if hasMore {
+		last := plays[len(plays)-1]
+		response["cursor"] = base64.RawURLEncoding.EncodeToString(playKey(last.StartTime, last.SongUUID))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// parseInt64Param parses an optional integer query parameter, returning def when empty.
+func parseInt64Param(value string, def int64) (int64, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("must be an integer")
+	}
+	return n, nil
+}
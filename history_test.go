@@ -0,0 +1,288 @@
+// ABOUTME: Unit tests for the play history store, recorder and endpoint.
+// ABOUTME: Uses a temporary bbolt database and a stubbed fetchMetadata.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// newTestHistoryStore opens a history store in a temporary directory
+func newTestHistoryStore(t *testing.T) *historyStore {
+	t.Helper()
+	store, err := openHistoryStore(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatalf("openHistoryStore returned an error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func TestHistoryStoreRecordDeduplicates(t *testing.T) {
+	store := newTestHistoryStore(t)
+
+	play := Play{SongUUID: "song-1", StartTime: 1700000000, Track: json.RawMessage(`{}`)}
+	inserted, err := store.Record("fip", play)
+	if err != nil {
+		t.Fatalf("Record returned an error: %v", err)
+	}
+	if !inserted {
+		t.Error("expected first Record to insert")
+	}
+
+	inserted, err = store.Record("fip", play)
+	if err != nil {
+		t.Fatalf("Record returned an error: %v", err)
+	}
+	if inserted {
+		t.Error("expected duplicate Record not to insert")
+	}
+
+	// Same song at a different start time is a separate play
+	play.StartTime = 1700003600
+	if inserted, _ := store.Record("fip", play); !inserted {
+		t.Error("expected replay at a new start time to insert")
+	}
+}
+
+func TestHistoryStoreQuery(t *testing.T) {
+	store := newTestHistoryStore(t)
+
+	for i := 0; i < 5; i++ {
+		play := Play{
+			SongUUID:  fmt.Sprintf("song-%d", i),
+			StartTime: int64(1000 + i*100),
+			Track:     json.RawMessage(`{}`),
+		}
+		if _, err := store.Record("fip_rock", play); err != nil {
+			t.Fatalf("Record returned an error: %v", err)
+		}
+	}
+
+	plays, hasMore, err := store.Query("fip_rock", 0, 1<<62, nil, 10)
+	if err != nil {
+		t.Fatalf("Query returned an error: %v", err)
+	}
+	if len(plays) != 5 || hasMore {
+		t.Fatalf("expected 5 plays without more, got %d (hasMore=%v)", len(plays), hasMore)
+	}
+	if plays[0].SongUUID != "song-4" || plays[4].SongUUID != "song-0" {
+		t.Errorf("expected newest first, got %s ... %s", plays[0].SongUUID, plays[4].SongUUID)
+	}
+
+	// Bounded range, inclusive on both ends
+	plays, _, err = store.Query("fip_rock", 1100, 1300, nil, 10)
+	if err != nil {
+		t.Fatalf("Query returned an error: %v", err)
+	}
+	if len(plays) != 3 || plays[0].StartTime != 1300 || plays[2].StartTime != 1100 {
+		t.Errorf("unexpected plays for range [1100, 1300]: %+v", plays)
+	}
+
+	// Limit reports that more plays remain
+	plays, hasMore, err = store.Query("fip_rock", 0, 1<<62, nil, 2)
+	if err != nil {
+		t.Fatalf("Query returned an error: %v", err)
+	}
+	if len(plays) != 2 || !hasMore {
+		t.Errorf("expected 2 plays with more, got %d (hasMore=%v)", len(plays), hasMore)
+	}
+
+	// Unknown bucket is empty rather than an error
+	plays, _, err = store.Query("fip_jazz", 0, 1<<62, nil, 10)
+	if err != nil || len(plays) != 0 {
+		t.Errorf("expected no plays for empty station, got %d (err=%v)", len(plays), err)
+	}
+}
+
+func TestHistoryRecorderRecordStation(t *testing.T) {
+	store := newTestHistoryStore(t)
+
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"fip","now":{"firstLine":{"title":"Song"},"songUuid":"uuid-1","startTime":1700000000,"endTime":1700000300}}`), nil
+	}
+
+	cache.Purge()
+
+	recorder := &historyRecorder{store: store, interval: time.Hour}
+	if err := recorder.recordStation(context.Background(), "fip"); err != nil {
+		t.Fatalf("recordStation returned an error: %v", err)
+	}
+	if err := recorder.recordStation(context.Background(), "fip"); err != nil {
+		t.Fatalf("recordStation returned an error: %v", err)
+	}
+
+	plays, _, err := store.Query("fip", 0, 1<<62, nil, 10)
+	if err != nil {
+		t.Fatalf("Query returned an error: %v", err)
+	}
+	if len(plays) != 1 {
+		t.Fatalf("expected exactly one recorded play, got %d", len(plays))
+	}
+	if plays[0].SongUUID != "uuid-1" || plays[0].EndTime != 1700000300 {
+		t.Errorf("unexpected recorded play: %+v", plays[0])
+	}
+
+	var track map[string]interface{}
+	if err := json.Unmarshal(plays[0].Track, &track); err != nil {
+		t.Fatalf("recorded track is not valid JSON: %v", err)
+	}
+	if fl, _ := track["firstLine"].(map[string]interface{}); fl["title"] != "Song" {
+		t.Errorf("expected transformed track to be stored, got %v", track)
+	}
+}
+
+func TestHistoryRecorderRunStops(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"` + param + `"}`), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	recorder := &historyRecorder{store: newTestHistoryStore(t), interval: time.Hour}
+	go func() {
+		recorder.Run(ctx)
+		close(done)
+	}()
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("recorder did not stop after context cancellation")
+	}
+}
+
+func TestHistoryHandler(t *testing.T) {
+	store := newTestHistoryStore(t)
+	originalHistory := history
+	history = store
+	defer func() { history = originalHistory }()
+
+	for i := 0; i < 3; i++ {
+		play := Play{SongUUID: fmt.Sprintf("song-%d", i), StartTime: int64(1000 + i), Track: json.RawMessage(`{}`)}
+		if _, err := store.Record("fip", play); err != nil {
+			t.Fatalf("Record returned an error: %v", err)
+		}
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/history/{param}", historyHandler)
+
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantPlays  int
+		wantCursor bool
+	}{
+		{"all", "/api/history/fip", http.StatusOK, 3, false},
+		{"paged", "/api/history/fip?limit=2", http.StatusOK, 2, true},
+		{"range", "/api/history/fip?from=1001&to=1001", http.StatusOK, 1, false},
+		{"unknown station", "/api/history/fip_nonexistent", http.StatusNotFound, 0, false},
+		{"bad limit", "/api/history/fip?limit=0", http.StatusBadRequest, 0, false},
+		{"bad from", "/api/history/fip?from=yesterday", http.StatusBadRequest, 0, false},
+		{"inverted range", "/api/history/fip?from=5&to=1", http.StatusBadRequest, 0, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", tc.url, nil)
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tc.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Plays  []Play `json:"plays"`
+				Cursor string `json:"cursor"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+			if len(resp.Plays) != tc.wantPlays {
+				t.Errorf("expected %d plays, got %d", tc.wantPlays, len(resp.Plays))
+			}
+			if (resp.Cursor != "") != tc.wantCursor {
+				t.Errorf("expected a cursor: %v, got %q", tc.wantCursor, resp.Cursor)
+			}
+		})
+	}
+}
+
+func TestHistoryHandlerCursorKeepsSharedStartTimes(t *testing.T) {
+	store := newTestHistoryStore(t)
+	originalHistory := history
+	history = store
+	defer func() { history = originalHistory }()
+
+	// Three plays share a start time, so a page of two splits them
+	for _, song := range []string{"song-a", "song-b", "song-c"} {
+		if _, err := store.Record("fip", Play{SongUUID: song, StartTime: 2000, Track: json.RawMessage(`{}`)}); err != nil {
+			t.Fatalf("Record returned an error: %v", err)
+		}
+	}
+	if _, err := store.Record("fip", Play{SongUUID: "song-0", StartTime: 1000, Track: json.RawMessage(`{}`)}); err != nil {
+		t.Fatalf("Record returned an error: %v", err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/history/{param}", historyHandler)
+
+	var songs []string
+	url := "/api/history/fip?limit=2"
+	for page := 0; page < 5; page++ {
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var resp struct {
+			Plays   []Play `json:"plays"`
+			HasMore bool   `json:"hasMore"`
+			Cursor  string `json:"cursor"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		for _, play := range resp.Plays {
+			songs = append(songs, play.SongUUID)
+		}
+		if !resp.HasMore {
+			break
+		}
+		if resp.Cursor == "" {
+			t.Fatal("expected a cursor when more plays remain")
+		}
+		url = "/api/history/fip?limit=2&cursor=" + resp.Cursor
+	}
+
+	if got := fmt.Sprint(songs); got != "[song-c song-b song-a song-0]" {
+		t.Errorf("expected every play exactly once, newest first, got %s", got)
+	}
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/history/fip?cursor=!!", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed cursor, got %d", rr.Code)
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
 
 const (
 	integrationTestEnv = "INTEGRATION_TESTS"
+	// recordFixturesEnv makes the suite overwrite testdata/livemeta with the payloads it fetches
+	recordFixturesEnv = "RECORD_FIXTURES"
 )
 
 // TestIntegrationConfig holds configuration for integration tests
@@ -54,6 +60,7 @@ func TestIntegrationSuite(t *testing.T) {
 		{"AllStations", testAllStations},
 		{"Caching", testCaching},
 		{"ConcurrentRequests", testConcurrentRequests},
+		{"LivemetaFixtures", testLivemetaFixtures},
 	}
 
 	// Run test cases
@@ -65,7 +72,7 @@ func TestIntegrationSuite(t *testing.T) {
 }
 
 func testFetchMetadata(t *testing.T, cfg *TestIntegrationConfig) {
-	data, err := fetchMetadata("fip")
+	data, err := fetchMetadata(context.Background(), "fip")
 	if err != nil {
 		t.Fatalf("Failed to fetch metadata: %v", err)
 	}
@@ -93,7 +100,7 @@ func testAllStations(t *testing.T, cfg *TestIntegrationConfig) {
 
 	for _, station := range stations {
 		t.Run(station, func(t *testing.T) {
-			data, err := fetchMetadata(station)
+			data, err := fetchMetadata(context.Background(), station)
 			if err != nil {
 				t.Fatalf("Failed to fetch metadata for %s: %v", station, err)
 			}
@@ -132,13 +139,13 @@ func testCaching(t *testing.T, cfg *TestIntegrationConfig) {
 	station := "fip"
 
 	// First request
-	data1, etag1, err := getCachedData(station)
+	data1, etag1, err := getCachedData(context.Background(), station)
 	if err != nil {
 		t.Fatalf("Failed to get initial data: %v", err)
 	}
 
 	// Immediate second request
-	data2, etag2, err := getCachedData(station)
+	data2, etag2, err := getCachedData(context.Background(), station)
 	if err != nil {
 		t.Fatalf("Failed to get cached data: %v", err)
 	}
@@ -147,14 +154,18 @@ func testCaching(t *testing.T, cfg *TestIntegrationConfig) {
 		t.Errorf("Cache inconsistency: ETags don't match. Expected %s, got %s", etag1, etag2)
 	}
 
-	// Wait for cache to expire
-	time.Sleep(cacheTTL + 100*time.Millisecond)
+	// Entries live until the track ends or delayToRefresh, so drop the entry rather than wait
+	cached, _ := cache.Peek(station)
+	cache.Purge()
 
 	// Third request
-	data3, _, err := getCachedData(station)
+	data3, _, err := getCachedData(context.Background(), station)
 	if err != nil {
 		t.Fatalf("Failed to get fresh data after cache expiry: %v", err)
 	}
+	if refetched, ok := cache.Peek(station); !ok || !refetched.CachedAt.After(cached.CachedAt) {
+		t.Errorf("Expected a fresh fetch after the entry was dropped")
+	}
 
 	// Validate all responses
 	for i, data := range [][]byte{data1, data2, data3} {
@@ -172,7 +183,7 @@ func testConcurrentRequests(t *testing.T, cfg *TestIntegrationConfig) {
 
 	for i := 0; i < concurrentRequests; i++ {
 		go func() {
-			data, _, err := getCachedData(station)
+			data, _, err := getCachedData(context.Background(), station)
 			if err != nil {
 				errChan <- err
 				return
@@ -193,3 +204,47 @@ func testConcurrentRequests(t *testing.T, cfg *TestIntegrationConfig) {
 		}
 	}
 }
+
+// testLivemetaFixtures strictly decodes a live payload of each livemeta format, so fields
+// upstream sends that the models do not declare fail the suite. With RECORD_FIXTURES=1 the
+// payloads are written to testdata/livemeta for the unit tests.
+func testLivemetaFixtures(t *testing.T, cfg *TestIntegrationConfig) {
+	fixtures := []struct {
+		station string
+		file    string
+	}{
+		{"fip", "fip_player.json"},           // webrf_fip_player
+		{"fip_rock", "webradio_player.json"}, // webrf_webradio_player
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.station, func(t *testing.T) {
+			station, ok := registry.Lookup(fixture.station)
+			if !ok {
+				t.Fatalf("unknown station %s", fixture.station)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout)
+			defer cancel()
+
+			data, err := upstream.Fetch(ctx, fixture.station, fmt.Sprintf("%s/%d/%s", baseURL, station.ID, station.Format))
+			if err != nil {
+				t.Fatalf("Failed to fetch %s: %v", station.Format, err)
+			}
+			if _, err := decodeLivemeta(fixture.station, data, decodeStrict); err != nil {
+				t.Errorf("%s payload does not match the livemeta models: %v", station.Format, err)
+			}
+
+			if os.Getenv(recordFixturesEnv) == "" {
+				return
+			}
+			var indented bytes.Buffer
+			if err := json.Indent(&indented, data, "", "  "); err != nil {
+				t.Fatalf("Invalid JSON from %s: %v", station.Format, err)
+			}
+			indented.WriteByte('\n')
+			if err := os.WriteFile(filepath.Join("testdata", "livemeta", fixture.file), indented.Bytes(), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
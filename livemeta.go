@@ -0,0 +1,338 @@
+// ABOUTME: Typed models for the Radio France livemeta payload and the public /api/metadata response.
+// ABOUTME: Decoding is lenient or strict, and fields upstream adds are reported on GET /admin/schema.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	decodeLenient = "lenient"
+	decodeStrict  = "strict"
+)
+
+var (
+	// upstreamDecoding is decodeLenient, which keeps whatever fields decode and counts the rest
+	// as decode errors, or decodeStrict, which rejects payloads with mistyped or unknown fields
+	upstreamDecoding = decodeLenient
+
+	// unknownFields records fields upstream sent that the livemeta models do not declare
+	unknownFields = newSchemaDrift()
+)
+
+// livemetaResponse is the payload of GET {baseURL}/{stationID}/{format}
+type livemetaResponse struct {
+	Prev           []livemetaTrack `json:"prev"`
+	Now            *livemetaTrack  `json:"now"`
+	Next           []livemetaTrack `json:"next"`
+	DelayToRefresh *float64        `json:"delayToRefresh"` // milliseconds
+}
+
+// livemetaTrack is one song in a livemeta payload. Times are Unix seconds.
+type livemetaTrack struct {
+	FirstLine  *string       `json:"firstLine"`  // song title
+	SecondLine *string       `json:"secondLine"` // artist
+	ThirdLine  *string       `json:"thirdLine"`
+	Cover      string        `json:"cover"` // image UUID under visualBaseURL
+	StartTime  *float64      `json:"startTime"`
+	EndTime    *float64      `json:"endTime"`
+	SongUUID   string        `json:"songUuid"`
+	Song       *livemetaSong `json:"song"`
+}
+
+// livemetaSong carries the identifiers, credits and release details of a track
+type livemetaSong struct {
+	ID            string                  `json:"id"`
+	Year          *int                    `json:"year"`
+	Release       *livemetaRelease        `json:"release"`
+	Interpreters  []string                `json:"interpreters"` // performers
+	Composers     []string                `json:"composers"`
+	ExternalLinks map[string]livemetaLink `json:"externalLinks"` // keyed by service, passed through as is
+}
+
+// livemetaLink is a song's page on an external service
+type livemetaLink struct {
+	ID   string `json:"id"`
+	Link string `json:"link"`
+}
+
+// livemetaRelease is the album a track was released on
+type livemetaRelease struct {
+	Title     string `json:"title"`
+	Label     string `json:"label"`
+	Reference string `json:"reference"`
+}
+
+// metadataResponse is the public /api/metadata payload. Its shape is what static/index.html
+// reads, so fields are only ever added.
+type metadataResponse struct {
+	StationName    string       `json:"stationName"`
+	DelayToRefresh *float64     `json:"delayToRefresh"`
+	Now            *publicTrack `json:"now,omitempty"`
+	Next           *publicTrack `json:"next,omitempty"`
+	Prev           *publicTrack `json:"prev,omitempty"`
+}
+
+// fullMetadataResponse is /api/metadata?full=1: the v1 payload with every upcoming
+// and recently played track rather than only the first of each
+type fullMetadataResponse struct {
+	StationName    string         `json:"stationName"`
+	DelayToRefresh *float64       `json:"delayToRefresh"`
+	Now            *publicTrack   `json:"now,omitempty"`
+	Next           []*publicTrack `json:"next"`
+	Prev           []*publicTrack `json:"prev"`
+	Stale          bool           `json:"stale,omitempty"` // set by markStale
+}
+
+// publicTrack is a track as served by /api/metadata
+type publicTrack struct {
+	FirstLine  *trackLine    `json:"firstLine,omitempty"`
+	SecondLine *trackLine    `json:"secondLine,omitempty"`
+	Visuals    *trackVisuals `json:"visuals,omitempty"`
+	StartTime  *float64      `json:"startTime,omitempty"`
+	EndTime    *float64      `json:"endTime,omitempty"`
+	SongUUID   string        `json:"songUuid,omitempty"`
+	Song       *songDetails  `json:"song,omitempty"`
+}
+
+// songDetails describes the release a track is from, who made it and where else to find it.
+// It is not part of the v1 shape.
+type songDetails struct {
+	Album      string         `json:"album,omitempty"`
+	Label      string         `json:"label,omitempty"`
+	Year       *int           `json:"year,omitempty"`
+	Performers []string       `json:"performers,omitempty"`
+	Composers  []string       `json:"composers,omitempty"`
+	Links      []externalLink `json:"links,omitempty"`
+}
+
+// externalLink is a song's page on a streaming service or radiofrance.fr
+type externalLink struct {
+	Service string `json:"service"`
+	URL     string `json:"url"`
+}
+
+type trackLine struct {
+	Title string `json:"title"`
+}
+
+type trackVisuals struct {
+	Card trackVisual `json:"card"`
+}
+
+type trackVisual struct {
+	Src string `json:"src"`
+}
+
+// decodeLivemeta decodes a livemeta payload for station. Each top-level field and each
+// next/prev track is decoded on its own: in lenient mode a field that does not decode is
+// counted in fip_decode_errors_total and left out (an object keeps the fields that did
+// decode), in strict mode it fails the payload.
+// Fields the models do not declare are recorded in unknownFields, and fail the payload
+// in strict mode.
+func decodeLivemeta(station string, data []byte, mode string) (*livemetaResponse, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		decodeErrors.WithLabelValues(station, "body").Inc()
+		return nil, &upstreamError{Station: station, Kind: upstreamKindMalformed, Err: fmt.Errorf("error unmarshalling JSON response: %v", err)}
+	}
+	if fields == nil {
+		decodeErrors.WithLabelValues(station, "body").Inc()
+		return nil, &upstreamError{Station: station, Kind: upstreamKindMalformed, Err: errors.New("received null response")}
+	}
+
+	var resp livemetaResponse
+	var errs []error
+	decodeField := func(field string, v interface{}) {
+		raw, ok := fields[field]
+		if !ok || string(raw) == "null" {
+			return
+		}
+		if err := json.Unmarshal(raw, v); err != nil {
+			decodeErrors.WithLabelValues(station, field).Inc()
+			errs = append(errs, fmt.Errorf("%s: %v", field, err))
+		}
+	}
+	decodeTracks := func(field string) []livemetaTrack {
+		var items []json.RawMessage
+		decodeField(field, &items)
+		tracks := make([]livemetaTrack, 0, len(items))
+		for i, item := range items {
+			var track livemetaTrack
+			if err := json.Unmarshal(item, &track); err != nil {
+				decodeErrors.WithLabelValues(station, field).Inc()
+				errs = append(errs, fmt.Errorf("%s[%d]: %v", field, i, err))
+				// Keep a track whose object decoded apart from some mistyped fields
+				var typeErr *json.UnmarshalTypeError
+				if !errors.As(err, &typeErr) || typeErr.Field == "" {
+					continue
+				}
+			}
+			tracks = append(tracks, track)
+		}
+		return tracks
+	}
+
+	decodeField("now", &resp.Now)
+	resp.Next = decodeTracks("next")
+	resp.Prev = decodeTracks("prev")
+	decodeField("delayToRefresh", &resp.DelayToRefresh)
+
+	unknown := undeclaredFields(data, reflect.TypeOf(resp), "")
+	unknownFields.Note(station, unknown)
+
+	if mode == decodeStrict {
+		if len(unknown) > 0 {
+			errs = append(errs, fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", ")))
+		}
+		if err := errors.Join(errs...); err != nil {
+			return nil, &upstreamError{Station: station, Kind: upstreamKindMalformed, Err: err}
+		}
+	} else if len(errs) > 0 {
+		slog.Debug("skipped livemeta fields that did not decode", "station", station, "error", errors.Join(errs...))
+	}
+	return &resp, nil
+}
+
+// undeclaredFields lists the object keys in raw that type t does not declare, recursing into
+// nested objects and arrays. Paths are dotted with [] marking array elements, sorted and unique.
+func undeclaredFields(raw json.RawMessage, t reflect.Type, path string) []string {
+	seen := make(map[string]bool)
+	collectUndeclared(raw, t, path, seen)
+
+	paths := make([]string, 0, len(seen))
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func collectUndeclared(raw json.RawMessage, t reflect.Type, path string, seen map[string]bool) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(raw, &fields) != nil {
+			return
+		}
+		declared := jsonFields(t)
+		for key, value := range fields {
+			fieldPath := key
+			if path != "" {
+				fieldPath = path + "." + key
+			}
+			fieldType, ok := declared[key]
+			if !ok {
+				seen[fieldPath] = true
+				continue
+			}
+			collectUndeclared(value, fieldType, fieldPath, seen)
+		}
+	case reflect.Slice:
+		var items []json.RawMessage
+		if json.Unmarshal(raw, &items) != nil {
+			return
+		}
+		for _, item := range items {
+			collectUndeclared(item, t.Elem(), path+"[]", seen)
+		}
+	}
+}
+
+// jsonFields maps the JSON names of a struct's fields to their types
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// schemaDrift remembers which undeclared upstream fields each station has sent, and when
+// each was first seen
+type schemaDrift struct {
+	mu     sync.Mutex
+	fields map[string]map[string]time.Time
+}
+
+// unknownField is an undeclared upstream field as reported by /admin/schema
+type unknownField struct {
+	Station   string    `json:"station"`
+	Field     string    `json:"field"`
+	FirstSeen time.Time `json:"firstSeen"`
+}
+
+func newSchemaDrift() *schemaDrift {
+	return &schemaDrift{fields: make(map[string]map[string]time.Time)}
+}
+
+// Note records the undeclared fields in a station's payload, logging each one the first time it appears
+func (s *schemaDrift) Note(station string, fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seen, ok := s.fields[station]
+	if !ok {
+		seen = make(map[string]time.Time)
+		s.fields[station] = seen
+	}
+	for _, field := range fields {
+		if _, ok := seen[field]; !ok {
+			seen[field] = time.Now()
+			slog.Warn("upstream sent an unknown field", "station", station, "field", field)
+		}
+	}
+}
+
+// Fields returns every undeclared field seen, sorted by station then field
+func (s *schemaDrift) Fields() []unknownField {
+	s.mu.Lock()
+	fields := make([]unknownField, 0)
+	for station, seen := range s.fields {
+		for field, firstSeen := range seen {
+			fields = append(fields, unknownField{Station: station, Field: field, FirstSeen: firstSeen.UTC()})
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(fields, func(i, j int) bool {
+		if fields[i].Station != fields[j].Station {
+			return fields[i].Station < fields[j].Station
+		}
+		return fields[i].Field < fields[j].Field
+	})
+	return fields
+}
+
+// schemaHandler serves GET /admin/schema
+func schemaHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"decoding":      upstreamDecoding,
+		"unknownFields": unknownFields.Fields(),
+	})
+}
@@ -0,0 +1,212 @@
+// ABOUTME: Structured logging with log/slog: level and format setup, request IDs and the access log.
+// ABOUTME: Each request gets an ID that tags every line logged with its context and one access log line.
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	logLevel  = "info" // debug, info, warn or error
+	logFormat = "text" // text or json
+
+	// quietPaths are probed constantly by the platform and logged at debug only
+	quietPaths = map[string]bool{"/healthz": true, "/readyz": true, "/metrics": true}
+)
+
+// validLogLevel reports whether level names an slog level
+func validLogLevel(level string) bool {
+	var lvl slog.Level
+	return lvl.UnmarshalText([]byte(level)) == nil
+}
+
+// setupLogging installs the default slog logger. Lines logged with a request context carry its request ID.
+func setupLogging(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch format {
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// contextHandler adds the request ID from the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := requestInfoFrom(ctx); info != nil {
+		r.AddAttrs(slog.String("request_id", info.id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type requestInfoKey struct{}
+
+// requestInfo collects what a request did for its access log line
+type requestInfo struct {
+	id string
+
+	mu       sync.Mutex
+	station  string
+	cache    string
+	upstream time.Duration
+}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// noteCacheOutcome records the station and cache outcome ("hit", "stale", "miss", ...) on the request, if any
+func noteCacheOutcome(ctx context.Context, station, outcome string) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.station, info.cache = station, outcome
+		info.mu.Unlock()
+	}
+}
+
+// noteUpstreamWait records how long the request waited on upstream
+func noteUpstreamWait(ctx context.Context, d time.Duration) {
+	if info := requestInfoFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.upstream += d
+		info.mu.Unlock()
+	}
+}
+
+// newRequestID returns the caller's X-Request-ID if it is reasonable, or a random ID
+func newRequestID(r *http.Request) string {
+	if id := r.Header.Get("X-Request-ID"); id != "" && len(id) <= 128 && !strings.ContainsAny(id, " \t\r\n") {
+		return id
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// accessLog assigns each request an ID, echoes it in X-Request-ID and logs one line when the request ends
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{id: newRequestID(r)}
+		ctx := context.WithValue(r.Context(), requestInfoKey{}, info)
+		w.Header().Set("X-Request-ID", info.id)
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Duration("duration", time.Since(start)),
+			slog.String("remote", r.RemoteAddr),
+		}
+		info.mu.Lock()
+		if info.station != "" {
+			attrs = append(attrs, slog.String("station", info.station))
+		}
+		if info.cache != "" {
+			attrs = append(attrs, slog.String("cache", info.cache))
+		}
+		if info.upstream > 0 {
+			attrs = append(attrs, slog.Duration("upstream", info.upstream))
+		}
+		info.mu.Unlock()
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case quietPaths[r.URL.Path]:
+			level = slog.LevelDebug
+		}
+		slog.LogAttrs(ctx, level, "request", attrs...)
+	})
+}
+
+// responseRecorder captures the status and size of a response. It passes through
+// flushing for SSE and hijacking for WebSockets.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status, r.wroteHeader = status, true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	r.status, r.wroteHeader = http.StatusSwitchingProtocols, true
+	return h.Hijack()
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// fatal logs an error and exits, for startup failures
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
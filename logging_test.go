@@ -0,0 +1,137 @@
+// ABOUTME: Unit tests for structured logging, request IDs and the access log middleware.
+// ABOUTME: Captures JSON log output in a buffer and decodes each line.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+// captureLogs installs a JSON logger at the given level writing to a buffer for the test
+func captureLogs(t *testing.T, level string) *bytes.Buffer {
+	t.Helper()
+	original := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(original) })
+
+	var buf bytes.Buffer
+	if err := setupLogging(&buf, level, "json"); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+// logLines decodes every JSON log line in buf
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not JSON: %q", line)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestSetupLoggingRejectsInvalidSettings(t *testing.T) {
+	original := slog.Default()
+	defer slog.SetDefault(original)
+
+	if err := setupLogging(&bytes.Buffer{}, "loud", "json"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if err := setupLogging(&bytes.Buffer{}, "info", "xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestAccessLogTagsRequest(t *testing.T) {
+	buf := captureLogs(t, "debug")
+
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"fip_hiphop"}`), nil
+	}
+	cache.Purge()
+
+	router := mux.NewRouter()
+	router.Use(accessLog)
+	router.HandleFunc("/api/metadata/{param}", handler)
+
+	req := httptest.NewRequest("GET", "/api/metadata/fip_hiphop", nil)
+	req.Header.Set("X-Request-ID", "req-123")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+
+	if got := rr.Header().Get("X-Request-ID"); got != "req-123" {
+		t.Errorf("expected request ID to be echoed, got %q", got)
+	}
+
+	var access map[string]interface{}
+	for _, line := range logLines(t, buf) {
+		if line["msg"] == "request" {
+			access = line
+		}
+	}
+	if access == nil {
+		t.Fatal("expected an access log line")
+	}
+	if access["request_id"] != "req-123" || access["status"] != float64(200) || access["station"] != "fip_hiphop" || access["cache"] != "miss" || access["upstream"] == nil {
+		t.Errorf("unexpected access log line: %v", access)
+	}
+}
+
+func TestAccessLogGeneratesRequestID(t *testing.T) {
+	captureLogs(t, "info")
+
+	router := mux.NewRouter()
+	router.Use(accessLog)
+	router.HandleFunc("/healthz", healthzHandler)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if id := rr.Header().Get("X-Request-ID"); len(id) != 16 {
+		t.Errorf("expected a generated 16 character request ID, got %q", id)
+	}
+}
+
+func TestAccessLogQuietPathsAtDebug(t *testing.T) {
+	buf := captureLogs(t, "info")
+
+	router := mux.NewRouter()
+	router.Use(accessLog)
+	router.HandleFunc("/healthz", healthzHandler)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+
+	if buf.Len() != 0 {
+		t.Errorf("expected health checks not to be logged at info, got %s", buf.String())
+	}
+}
+
+func TestResponseRecorderPassesThroughFlush(t *testing.T) {
+	rec := &responseRecorder{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK}
+	var w http.ResponseWriter = rec
+	if _, ok := w.(http.Flusher); !ok {
+		t.Fatal("expected responseRecorder to implement http.Flusher")
+	}
+
+	rec.WriteHeader(http.StatusTeapot)
+	rec.WriteHeader(http.StatusOK)
+	rec.Write([]byte("short and stout"))
+	if rec.status != http.StatusTeapot || rec.bytes != 15 {
+		t.Errorf("expected first status and byte count recorded, got %d %d", rec.status, rec.bytes)
+	}
+}
@@ -1,201 +1,585 @@
 package main
 
 import (
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-// CachedResponse stores the response data and the time it was cached
+// CachedResponse stores the response data and the time it was cached.
+// Data is the v1 payload; Full keeps every next/prev track (see v1Payload).
+// ExpiresAt is set from the payload (see entryTTL); entries without it live for cacheMinTTL.
 type CachedResponse struct {
-	Data     []byte
-	CachedAt time.Time
+	Data      []byte
+	Full      []byte
+	CachedAt  time.Time
+	ExpiresAt time.Time
 }
 
-// stationConfig holds the numeric ID and API format for a FIP channel
+// newCachedResponse caches a payload returned by fetchMetadata along with its v1 view
+func newCachedResponse(full []byte, cachedAt, expiresAt time.Time) CachedResponse {
+	return CachedResponse{Data: v1Payload(full), Full: full, CachedAt: cachedAt, ExpiresAt: expiresAt}
+}
+
+// entryTTL is how long a payload fetched at now is fresh: until its delayToRefresh or the
+// end of its current track, bounded by minTTL and maxTTL (normally cacheMinTTL and cacheMaxTTL)
+func entryTTL(data []byte, now time.Time, minTTL, maxTTL time.Duration) time.Duration {
+	var delay time.Duration
+	if summary, err := summarizePayload(data); err == nil {
+		delay = untilRefresh(summary, now)
+	}
+	return clampDuration(delay, minTTL, maxTTL)
+}
+
+// full returns the payload with complete next/prev arrays, or Data for entries stored without one
+func (c CachedResponse) full() []byte {
+	if c.Full != nil {
+		return c.Full
+	}
+	return c.Data
+}
+
+// expiry returns the moment the entry stops being fresh
+func (c CachedResponse) expiry() time.Time {
+	if !c.ExpiresAt.IsZero() {
+		return c.ExpiresAt
+	}
+	return c.CachedAt.Add(cacheMinTTL)
+}
+
+// fresh reports whether the entry can still be served without refetching
+func (c CachedResponse) fresh(now time.Time) bool {
+	return now.Before(c.expiry())
+}
+
+// staleFor reports how long the entry has been expired; zero or negative while fresh
+func (c CachedResponse) staleFor(now time.Time) time.Duration {
+	return now.Sub(c.expiry())
+}
+
+// Warning header values for stale responses (RFC 7234 section 5.5)
+const (
+	warningStale              = `110 - "Response is Stale"`
+	warningRevalidationFailed = `111 - "Revalidation Failed"`
+)
+
+// cacheResult is a payload served from the cache layer. Data is the v1 payload and ETag
+// its tag; Full keeps every next/prev track. FetchedAt is when upstream was called. Stale
+// results carry the Warning to send and their Age since they were fetched.
+type cacheResult struct {
+	Data      []byte
+	ETag      string
+	Full      []byte
+	FetchedAt time.Time
+	ExpiresAt time.Time // when the entry stops being fresh
+	Stale     bool
+	Age       time.Duration
+	Warning   string
+}
+
+// stationConfig holds the numeric ID and API format for a FIP channel, plus the
+// optional descriptive fields served by /api/stations
 type stationConfig struct {
-	ID     int
-	Format string
+	ID          int    `yaml:"id"`
+	Format      string `yaml:"format"`
+	DisplayName string `yaml:"display_name"`
+	Genre       string `yaml:"genre"`
+	Logo        string `yaml:"logo"`
+	StreamSlug  string `yaml:"stream_slug"` // Icecast/HLS mount name; defaults to the station name without underscores
 }
 
 var (
-	cache      = make(map[string]CachedResponse)
-	cacheMutex sync.Mutex
-	cacheTTL   = 1 * time.Second // Cache Time-To-Live
-	baseURL    = "https://api.radiofrance.fr/livemeta/live"
+	// serverCtx is cancelled when the server shuts down. Request contexts, background jobs
+	// and coalesced upstream fetches all derive from it.
+	serverCtx, cancelServerCtx = context.WithCancel(context.Background())
+
+	// drainCtx is cancelled when shutdown begins: readiness starts failing and streams are
+	// closed so in-flight requests can finish before serverCtx is cancelled.
+	drainCtx, startDrain = context.WithCancel(context.Background())
+	drainDelay           = 5 * time.Second  // How long /readyz fails before the listener closes
+	shutdownTimeout      = 25 * time.Second // How long in-flight requests get to drain
+	readHeaderTimeout    = 10 * time.Second // How long a client may take to send request headers
+
+	fetchGroup = flightGroup{base: serverCtx} // Coalesces concurrent upstream fetches per station
+
+	// Each entry is fresh until its payload says to refresh (see entryTTL), but for at least
+	// cacheMinTTL and at most cacheMaxTTL
+	cacheMinTTL = 5 * time.Second
+	cacheMaxTTL = 2 * time.Minute
+
+	// staleWhileRevalidate is how long past expiry an entry is still served while it is
+	// refreshed in the background; staleIfError is the maximum staleness served when the
+	// refresh fails. Entries older than both are dropped.
+	staleWhileRevalidate = 30 * time.Second
+	staleIfError         = 10 * time.Minute
+	baseURL              = "https://api.radiofrance.fr/livemeta/live"
+
+	visualBaseURL   = "https://www.radiofrance.fr/pikapi/images"
+	upstreamTimeout = 10 * time.Second // Timeout for a single upstream attempt, including the body
+	listenAddr      = ":8080"
+	adminListenAddr = "localhost:8081" // Serves /admin/*, kept off the public listener; empty disables it
 
 	// stationMap maps channel names to their Radio France station IDs and API formats.
 	// The main FIP station uses "webrf_fip_player"; webradios use "webrf_webradio_player".
+	// These static stations are merged over the station catalogue in the registry.
 	stationMap = map[string]stationConfig{
-		"fip":             {ID: 7, Format: "webrf_fip_player"},
-		"fip_rock":        {ID: 64, Format: "webrf_webradio_player"},
-		"fip_jazz":        {ID: 65, Format: "webrf_webradio_player"},
-		"fip_groove":      {ID: 66, Format: "webrf_webradio_player"},
-		"fip_world":       {ID: 69, Format: "webrf_webradio_player"},
-		"fip_nouveautes":  {ID: 70, Format: "webrf_webradio_player"},
-		"fip_reggae":      {ID: 71, Format: "webrf_webradio_player"},
-		"fip_electro":     {ID: 74, Format: "webrf_webradio_player"},
-		"fip_metal":       {ID: 77, Format: "webrf_webradio_player"},
-		"fip_pop":         {ID: 78, Format: "webrf_webradio_player"},
-		"fip_hiphop":      {ID: 95, Format: "webrf_webradio_player"},
-		"fip_cultes":      {ID: 709, Format: "webrf_webradio_player"},
+		"fip":            {ID: 7, Format: "webrf_fip_player", DisplayName: "FIP", Genre: "eclectic"},
+		"fip_rock":       {ID: 64, Format: "webrf_webradio_player", DisplayName: "FIP Rock", Genre: "rock"},
+		"fip_jazz":       {ID: 65, Format: "webrf_webradio_player", DisplayName: "FIP Jazz", Genre: "jazz"},
+		"fip_groove":     {ID: 66, Format: "webrf_webradio_player", DisplayName: "FIP Groove", Genre: "groove"},
+		"fip_world":      {ID: 69, Format: "webrf_webradio_player", DisplayName: "FIP World", Genre: "world"},
+		"fip_nouveautes": {ID: 70, Format: "webrf_webradio_player", DisplayName: "Tout nouveau, tout FIP", Genre: "new releases"},
+		"fip_reggae":     {ID: 71, Format: "webrf_webradio_player", DisplayName: "FIP Reggae", Genre: "reggae"},
+		"fip_electro":    {ID: 74, Format: "webrf_webradio_player", DisplayName: "FIP Electro", Genre: "electronic"},
+		"fip_metal":      {ID: 77, Format: "webrf_webradio_player", DisplayName: "FIP Metal", Genre: "metal"},
+		"fip_pop":        {ID: 78, Format: "webrf_webradio_player", DisplayName: "FIP Pop", Genre: "pop"},
+		"fip_hiphop":     {ID: 95, Format: "webrf_webradio_player", DisplayName: "FIP Hip-Hop", Genre: "hip-hop"},
+		"fip_cultes":     {ID: 709, Format: "webrf_webradio_player", DisplayName: "FIP Cultes", Genre: "classics"},
 	}
 )
 
 func main() {
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+	if err := setupLogging(os.Stderr, cfg.Log.Level, cfg.Log.Format); err != nil {
+		fatal("invalid logging configuration", "error", err)
+	}
+	applyConfig(cfg)
+
 	router := mux.NewRouter()
+	router.Use(accessLog)
+
+	// API routes
+	registerAPI(router)
+	router.HandleFunc("/ws", wsHandler).Methods("GET")
 
-	// API route
-	router.HandleFunc("/api/metadata/{param}", handler).Methods("GET")
+	// Health checks
+	router.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	router.HandleFunc("/readyz", readyzHandler).Methods("GET")
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
 
 	// Serve the index.html file for documentation
 	router.PathPrefix("/").Handler(http.FileServer(http.Dir("./static/")))
 
-	log.Println("Server starting on :8080")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	// Background jobs run until serverCtx is cancelled at shutdown
+	var background sync.WaitGroup
+	goBackground := func(job func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			job()
+		}()
+	}
+
+	// Load the station catalogue, if configured, on top of the static stations
+	if catalogURL != "" {
+		goBackground(func() { runCatalogRefresher(serverCtx, catalogURL, catalogRefreshInterval) })
+	}
+
+	// Keep every station warm so requests are served from memory
+	goBackground(func() { runPrefetcher(serverCtx, registry.Names) })
+	goBackground(func() { runCacheJanitor(serverCtx, cacheJanitorInterval) })
+
+	// Record play history in the background; the API keeps working without it
+	if historyPath == "" {
+		slog.Info("play history disabled: no history path configured")
+	} else if store, err := openHistoryStore(historyPath); err != nil {
+		slog.Warn("play history disabled", "error", err)
+	} else {
+		history = store
+		recorder := &historyRecorder{store: store, interval: historyInterval}
+		goBackground(func() { recorder.Run(serverCtx) })
+	}
+
+	servers := []*http.Server{newServer(listenAddr, router)}
+
+	// Admin routes expose internals, so they only answer on the internal listener
+	if adminListenAddr == "" {
+		slog.Info("admin endpoints disabled: no admin listen address configured")
+	} else {
+		admin := mux.NewRouter()
+		admin.Use(accessLog)
+		registerAdmin(admin)
+		servers = append(servers, newServer(adminListenAddr, admin))
+	}
+
+	for _, server := range servers {
+		go func(server *http.Server) {
+			slog.Info("server starting", "addr", server.Addr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("server failed", "addr", server.Addr, "error", err)
+			}
+		}(server)
+	}
+
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-signals.Done()
+	stop()
+
+	shutdown(servers...)
+	background.Wait()
+	if history != nil {
+		if err := history.Close(); err != nil {
+			slog.Error("error closing history database", "error", err)
+		}
+	}
+	slog.Info("server stopped")
+}
+
+// newServer builds an HTTP server whose request contexts derive from serverCtx
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		BaseContext:       func(net.Listener) context.Context { return serverCtx },
+	}
+}
+
+// registerAdmin adds the operational endpoints served on the admin listener
+func registerAdmin(router *mux.Router) {
+	router.HandleFunc("/admin/breakers", breakersHandler).Methods("GET")
+	router.HandleFunc("/admin/schema", schemaHandler).Methods("GET")
+	router.HandleFunc("/admin/cache", cacheHandler).Methods("GET")
 }
 
+// shutdown fails /readyz for drainDelay so load balancers stop routing new requests here,
+// then stops accepting connections and waits up to shutdownTimeout for in-flight requests,
+// SSE streams and WebSockets to finish, then cancels everything still running
+func shutdown(servers ...*http.Server) {
+	slog.Info("shutting down, failing readiness", "delay", drainDelay)
+	startDrain()
+	time.Sleep(drainDelay)
+
+	slog.Info("draining connections", "timeout", shutdownTimeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			slog.Error("error draining connections", "addr", server.Addr, "error", err)
+			server.Close()
+		}
+	}
+
+	sockets := make(chan struct{})
+	go func() {
+		webSockets.Wait()
+		close(sockets)
+	}()
+	select {
+	case <-sockets:
+	case <-ctx.Done():
+		slog.Warn("timed out waiting for WebSocket clients to close")
+	}
+
+	// Stops background jobs and cancels any upstream call still in flight
+	cancelServerCtx()
+}
+
+// handler serves GET /api/v1/metadata/{param}: the v1 payload, or with ?full=1 every
+// next and prev track
 func handler(w http.ResponseWriter, r *http.Request) {
+	full := false
+	if value := r.URL.Query().Get("full"); value != "" {
+		var err error
+		if full, err = strconv.ParseBool(value); err != nil {
+			writeProblem(w, http.StatusBadRequest, "Invalid parameter", fmt.Sprintf("full must be a boolean, got %q", value))
+			return
+		}
+	}
+
+	serveMetadata(w, r, func(result cacheResult, _ trackTiming) ([]byte, string, error) {
+		if full {
+			return result.Full, generateETag(result.Full), nil
+		}
+		return result.Data, result.ETag, nil
+	})
+}
+
+// serveMetadata looks up the station named by the param route variable and writes the
+// payload render builds from it, answering If-None-Match with 304 Not Modified.
+// HTTP caches may keep the response until its cache entry expires or the current track
+// ends, whichever is sooner.
+func serveMetadata(w http.ResponseWriter, r *http.Request, render func(cacheResult, trackTiming) ([]byte, string, error)) {
 	vars := mux.Vars(r)
 	fipParam, ok := vars["param"]
 	if !ok {
-		log.Println("Missing 'param' parameter in request")
-		http.Error(w, "Missing 'param' parameter", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Missing parameter", "missing 'param' parameter")
 		return
 	}
 
-	log.Printf("Fetching data for param: %s\n", fipParam)
-	data, etag, err := getCachedData(fipParam)
+	result, err := lookupCachedData(r.Context(), fipParam)
 	if err != nil {
-		log.Printf("Error fetching data for param: %s, error: %v\n", fipParam, err)
-		errorResponse := map[string]string{
-			"error":   "API Error",
-			"message": err.Error(),
+		if r.Context().Err() != nil {
+			slog.DebugContext(r.Context(), "request abandoned", "station", fipParam, "error", err)
+			return
 		}
-		jsonResp, _ := json.Marshal(errorResponse)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(jsonResp)
+		slog.WarnContext(r.Context(), "error fetching data", "station", fipParam, "error", err)
+		status := writeError(w, r, err)
+		metadataResponses.WithLabelValues(strconv.Itoa(status)).Inc()
+		return
+	}
+	timing := newTrackTiming(result.Data, result.FetchedAt, time.Now())
+	data, etag, err := render(result, timing)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "error rendering response", "station", fipParam, "error", err)
+		status := writeError(w, r, err)
+		metadataResponses.WithLabelValues(strconv.Itoa(status)).Inc()
 		return
 	}
 
+	if result.Stale {
+		w.Header().Set("Warning", result.Warning)
+		w.Header().Set("Age", strconv.Itoa(int(result.Age.Seconds())))
+	}
+	setCacheHeaders(w, timing.cacheExpiry(result.ExpiresAt), timing.ServerTime)
+
 	// Check if the client has a cached version
 	clientETag := r.Header.Get("If-None-Match")
 	if clientETag == etag {
 		w.WriteHeader(http.StatusNotModified)
+		metadataResponses.WithLabelValues("304").Inc()
 		return
 	}
+	metadataResponses.WithLabelValues("200").Inc()
 
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("ETag", etag)
+	setCORSHeaders(w)
+	if _, err := w.Write(data); err != nil {
+		slog.DebugContext(r.Context(), "error writing response", "error", err)
+		return
+	}
+}
+
+// setCORSHeaders allows browser clients on any origin to read API responses
+func setCORSHeaders(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Requested-With, Accept, Origin, User-Agent, Cache-Control, Pragma")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 	w.Header().Set("Access-Control-Max-Age", "86400")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	if _, err := w.Write(data); err != nil {
-		log.Printf("Error writing response: %v", err)
-		http.Error(w, "Error writing response", http.StatusInternalServerError)
+}
+
+// writeJSON marshals v and writes it with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	writeJSONAs(w, status, "application/json", v)
+}
+
+// writeJSONAs marshals v and writes it with the given status code and content type
+func writeJSONAs(w http.ResponseWriter, status int, contentType string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("error marshalling response", "error", err)
+		http.Error(w, "Error marshalling response", http.StatusInternalServerError)
 		return
 	}
-
+	w.Header().Set("Content-Type", contentType)
+	setCORSHeaders(w)
+	w.WriteHeader(status)
+	if _, err := w.Write(data); err != nil {
+		slog.Debug("error writing response", "error", err)
+	}
 }
 
-func getCachedData(param string) ([]byte, string, error) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
+// getCachedData returns the transformed payload for a station and its ETag.
+// See lookupCachedData for how stale entries are served.
+func getCachedData(ctx context.Context, param string) ([]byte, string, error) {
+	result, err := lookupCachedData(ctx, param)
+	if err != nil {
+		return nil, "", err
+	}
+	return result.Data, result.ETag, nil
+}
 
-	log.Printf("Checking cache for param: %s\n", param)
+// lookupCachedData returns the payload for a station, fetching it on a miss.
+// An entry expired by less than staleWhileRevalidate is served immediately while a background
+// refresh runs; when a synchronous refresh fails, an entry expired by less than staleIfError
+// is served instead of the error. Stale payloads are marked with "stale": true.
+//
+// The cache is never locked across an upstream call; concurrent misses for the same station
+// share one upstream call, while misses for different stations proceed in parallel.
+// When ctx is done the caller stops waiting; the shared call only stops once all its callers have.
+//
+// Unknown stations are rejected before the cache or metrics see them, so arbitrary names in
+// URLs cannot add cache entries or metric series.
+func lookupCachedData(ctx context.Context, param string) (cacheResult, error) {
+	if _, ok := registry.Lookup(param); !ok {
+		return cacheResult{}, &unknownStationError{Station: param}
+	}
 
-	// Check if data is cached and still valid
-	if cachedResponse, found := cache[param]; found {
-		if time.Since(cachedResponse.CachedAt) < cacheTTL {
-			log.Printf("Cache hit for param: %s\n", param)
-			return cachedResponse.Data, generateETag(cachedResponse.Data), nil
+	cachedResponse, found := cache.Get(param)
+
+	now := time.Now()
+	if found {
+		if cachedResponse.fresh(now) {
+			noteCacheOutcome(ctx, param, "hit")
+			cacheHits.WithLabelValues(param).Inc()
+			return cacheResult{
+				Data:      cachedResponse.Data,
+				ETag:      generateETag(cachedResponse.Data),
+				Full:      cachedResponse.full(),
+				FetchedAt: cachedResponse.CachedAt,
+				ExpiresAt: cachedResponse.expiry(),
+			}, nil
 		}
-		// Remove stale cache
-		log.Printf("Cache expired for param: %s, fetching new data\n", param)
-		delete(cache, param)
+		if cachedResponse.staleFor(now) < staleWhileRevalidate {
+			slog.DebugContext(ctx, "serving stale entry while revalidating", "station", param)
+			noteCacheOutcome(ctx, param, "stale")
+			cacheHits.WithLabelValues(param).Inc()
+			go revalidate(param)
+			return staleResult(cachedResponse, now, warningStale), nil
+		}
+		noteCacheOutcome(ctx, param, "expired")
 	} else {
-		log.Printf("Cache miss for param: %s\n", param)
+		noteCacheOutcome(ctx, param, "miss")
 	}
+	cacheMisses.WithLabelValues(param).Inc()
+
+	start := time.Now()
 
-	// Fetch new data
-	data, err := fetchMetadata(param)
+	data, shared, err := fetchGroup.Do(ctx, param, loadStation(param))
+	noteUpstreamWait(ctx, time.Since(start))
 	if err != nil {
-		return nil, "", err
+		if ctx.Err() != nil {
+			return cacheResult{}, err
+		}
+		if found && cachedResponse.staleFor(now) < staleIfError {
+			slog.WarnContext(ctx, "serving stale entry after upstream error", "station", param, "error", err)
+			noteCacheOutcome(ctx, param, "stale-if-error")
+			return staleResult(cachedResponse, now, warningRevalidationFailed), nil
+		}
+		if found {
+			cache.Remove(param, cachedResponse.CachedAt)
+		}
+		return cacheResult{}, err
+	}
+	if shared {
+		slog.DebugContext(ctx, "shared in-flight fetch", "station", param)
 	}
 
-	// Cache the new data
-	cache[param] = CachedResponse{Data: data, CachedAt: time.Now()}
-	log.Printf("New data cached for param: %s\n", param)
-
-	return data, generateETag(data), nil
+	v1 := v1Payload(data)
+	return cacheResult{
+		Data:      v1,
+		ETag:      generateETag(v1),
+		Full:      data,
+		FetchedAt: start,
+		ExpiresAt: start.Add(entryTTL(data, start, cacheMinTTL, cacheMaxTTL)),
+	}, nil
 }
 
-// Make fetchMetadata a variable so it can be replaced in tests
-var fetchMetadata = func(param string) ([]byte, error) {
-	station, ok := stationMap[param]
-	if !ok {
-		return nil, fmt.Errorf("unknown station: %s", param)
+// peekCachedData returns the cached payload for a station without fetching, as long as
+// it is still within the maximum staleness
+func peekCachedData(param string) ([]byte, bool) {
+	cachedResponse, found := cache.Peek(param)
+	if !found || cachedResponse.staleFor(time.Now()) >= staleIfError {
+		return nil, false
 	}
+	return cachedResponse.Data, true
+}
 
-	url := fmt.Sprintf("%s/%d/%s", baseURL, station.ID, station.Format)
-	log.Printf("Fetching data from: %s\n", url)
+// loadStation returns a fetch of a station that stores the result in the cache.
+// Callers run it through fetchGroup so concurrent loads of one station are coalesced.
+func loadStation(param string) func(ctx context.Context) ([]byte, error) {
+	fetch := fetchMetadata // the load may outlive its caller
+	minTTL, maxTTL := cacheMinTTL, cacheMaxTTL
+	return func(ctx context.Context) ([]byte, error) {
+		data, err := fetch(ctx, param)
+		if err != nil {
+			return nil, err
+		}
 
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("error creating request for %s: %v", param, err)
-	}
-	req.Header.Set("Accept-Encoding", "gzip, deflate")
+		// An expired entry is only replaced once the refresh succeeds
+		now := time.Now()
+		cache.Set(param, newCachedResponse(data, now, now.Add(entryTTL(data, now, minTTL, maxTTL))))
+		slog.DebugContext(ctx, "cached new data", "station", param)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching data for %s: %v", param, err)
+		return data, nil
 	}
-	defer resp.Body.Close()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("received non-200 response code for %s: %d", param, resp.StatusCode)
+// revalidate refreshes a stale entry in the background
+func revalidate(param string) {
+	if _, _, err := fetchGroup.Do(serverCtx, param, loadStation(param)); err != nil {
+		slog.Warn("background revalidation failed", "station", param, "error", err)
 	}
+}
 
-	// Handle gzip-compressed responses
-	var reader io.Reader = resp.Body
-	if resp.Header.Get("Content-Encoding") == "gzip" {
-		gzReader, err := gzip.NewReader(resp.Body)
-		if err != nil {
-			return nil, fmt.Errorf("error creating gzip reader for %s: %v", param, err)
-		}
-		defer gzReader.Close()
-		reader = gzReader
+// staleResult builds a cache result for an expired entry, marking its payload as stale
+func staleResult(entry CachedResponse, now time.Time, warning string) cacheResult {
+	data := markStale(entry.Data)
+	return cacheResult{
+		Data:      data,
+		ETag:      generateETag(data),
+		Full:      markStale(entry.full()),
+		FetchedAt: entry.CachedAt,
+		ExpiresAt: entry.expiry(),
+		Stale:     true,
+		Age:       now.Sub(entry.CachedAt),
+		Warning:   warning,
 	}
+}
 
-	data, err := io.ReadAll(reader)
+// markStale adds "stale": true to a JSON object payload. Payloads that are not
+// objects are returned unchanged.
+func markStale(data []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return data
+	}
+	fields["stale"] = json.RawMessage("true")
+	marked, err := json.Marshal(fields)
 	if err != nil {
-		return nil, fmt.Errorf("error reading response body for %s: %v", param, err)
+		return data
 	}
+	return marked
+}
 
-	var rawResponse map[string]interface{}
-	if err := json.Unmarshal(data, &rawResponse); err != nil {
-		return nil, fmt.Errorf("error unmarshalling JSON response for %s: %v", param, err)
+// Make fetchMetadata a variable so it can be replaced in tests
+var fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+	station, ok := registry.Lookup(param)
+	if !ok {
+		return nil, &unknownStationError{Station: param}
 	}
 
-	if rawResponse == nil {
-		return nil, fmt.Errorf("received null response from FIP API for %s", param)
+	url := fmt.Sprintf("%s/%d/%s", baseURL, station.ID, station.Format)
+
+	data, err := upstream.Fetch(ctx, param, url)
+	if err != nil {
+		return nil, err
 	}
 
-	transformed := transformResponse(rawResponse, param)
+	raw, err := decodeLivemeta(param, data, upstreamDecoding)
+	if err != nil {
+		return nil, err
+	}
 
-	result, err := json.Marshal(transformed)
+	result, err := json.Marshal(transformResponse(raw, param))
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling transformed response for %s: %v", param, err)
 	}
@@ -203,72 +587,150 @@ var fetchMetadata = func(param string) ([]byte, error) {
 	return result, nil
 }
 
-const visualBaseURL = "https://www.radiofrance.fr/pikapi/images"
-
 // transformTrack converts a track from the new livemeta format to the old format
 // that the frontend expects: firstLine/secondLine as objects with title, visuals with card src.
-func transformTrack(track map[string]interface{}) map[string]interface{} {
-	result := map[string]interface{}{}
+func transformTrack(track livemetaTrack) *publicTrack {
+	result := &publicTrack{
+		StartTime: track.StartTime,
+		EndTime:   track.EndTime,
+		SongUUID:  track.SongUUID,
+	}
 
 	// firstLine: string → {title: string}
-	if fl, ok := track["firstLine"].(string); ok {
-		result["firstLine"] = map[string]interface{}{"title": fl}
+	if track.FirstLine != nil {
+		result.FirstLine = &trackLine{Title: *track.FirstLine}
 	}
 	// secondLine: string → {title: string}
-	if sl, ok := track["secondLine"].(string); ok {
-		result["secondLine"] = map[string]interface{}{"title": sl}
+	if track.SecondLine != nil {
+		result.SecondLine = &trackLine{Title: *track.SecondLine}
 	}
 
 	// cover UUID → visuals.card.src
-	if cover, ok := track["cover"].(string); ok && cover != "" {
-		result["visuals"] = map[string]interface{}{
-			"card": map[string]interface{}{
-				"src": fmt.Sprintf("%s/%s", visualBaseURL, cover),
-			},
+	if track.Cover != "" {
+		result.Visuals = &trackVisuals{Card: trackVisual{Src: fmt.Sprintf("%s/%s", visualBaseURL, track.Cover)}}
+	}
+
+	// Release details and credits for v2 and ?full=1; v1Payload leaves them out
+	if track.Song != nil {
+		result.Song = transformSong(*track.Song)
+	}
+
+	return result
+}
+
+// transformSong extracts the release, credits and external links of a song; nil when it has none
+func transformSong(song livemetaSong) *songDetails {
+	details := &songDetails{
+		Year:       song.Year,
+		Performers: nonEmpty(song.Interpreters),
+		Composers:  nonEmpty(song.Composers),
+	}
+	if song.Release != nil {
+		details.Album, details.Label = song.Release.Title, song.Release.Label
+	}
+
+	// Sorted by service so the payload, and its ETag, are stable
+	for service, link := range song.ExternalLinks {
+		if link.Link != "" {
+			details.Links = append(details.Links, externalLink{Service: service, URL: link.Link})
 		}
 	}
+	sort.Slice(details.Links, func(i, j int) bool { return details.Links[i].Service < details.Links[j].Service })
 
-	// Preserve timing fields
-	if v, ok := track["startTime"]; ok {
-		result["startTime"] = v
+	if details.Album == "" && details.Label == "" && details.Year == nil &&
+		details.Performers == nil && details.Composers == nil && details.Links == nil {
+		return nil
 	}
-	if v, ok := track["endTime"]; ok {
-		result["endTime"] = v
+	return details
+}
+
+// nonEmpty drops blank names, returning nil when none are left
+func nonEmpty(names []string) []string {
+	var kept []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			kept = append(kept, name)
+		}
+	}
+	return kept
+}
+
+// transformResponse converts the livemeta API response to the format the frontend expects,
+// keeping every next and prev track. v1Payload collapses them for the v1 shape.
+func transformResponse(raw *livemetaResponse, stationName string) fullMetadataResponse {
+	result := fullMetadataResponse{
+		StationName:    stationName,
+		DelayToRefresh: raw.DelayToRefresh,
+		Next:           make([]*publicTrack, 0, len(raw.Next)),
+		Prev:           make([]*publicTrack, 0, len(raw.Prev)),
+	}
+
+	if raw.Now != nil {
+		result.Now = transformTrack(*raw.Now)
+	}
+	for _, track := range raw.Next {
+		result.Next = append(result.Next, transformTrack(track))
 	}
-	if v, ok := track["songUuid"]; ok {
-		result["songUuid"] = v
+	for _, track := range raw.Prev {
+		result.Prev = append(result.Prev, transformTrack(track))
 	}
 
 	return result
 }
 
-// transformResponse converts the livemeta API response to the format the frontend expects.
-func transformResponse(raw map[string]interface{}, stationName string) map[string]interface{} {
-	result := map[string]interface{}{
-		"stationName":    stationName,
-		"delayToRefresh": raw["delayToRefresh"],
-	}
+// v2TrackFields are the track fields of a full payload that the v1 shape never had
+var v2TrackFields = []string{"song"}
 
-	// Transform "now" (single object)
-	if now, ok := raw["now"].(map[string]interface{}); ok {
-		result["now"] = transformTrack(now)
+// v1Payload collapses the next and prev arrays of a full payload to their first element
+// and drops the v2TrackFields, as /api/metadata has always served it.
+// Other fields, and payloads that are not objects or already collapsed, are passed through unchanged.
+func v1Payload(full []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(full, &fields); err != nil || fields == nil {
+		return full
 	}
 
-	// Transform "next" (array → first element as single object for backward compat)
-	if nextArr, ok := raw["next"].([]interface{}); ok && len(nextArr) > 0 {
-		if nextTrack, ok := nextArr[0].(map[string]interface{}); ok {
-			result["next"] = transformTrack(nextTrack)
+	changed := false
+	for _, field := range []string{"now", "next", "prev"} {
+		raw, ok := fields[field]
+		if !ok {
+			continue
+		}
+		var tracks []json.RawMessage
+		if field != "now" && json.Unmarshal(raw, &tracks) == nil {
+			changed = true
+			if len(tracks) == 0 {
+				delete(fields, field)
+				continue
+			}
+			raw = tracks[0]
+			fields[field] = raw
 		}
-	}
 
-	// Transform "prev" (array → first element as single object)
-	if prevArr, ok := raw["prev"].([]interface{}); ok && len(prevArr) > 0 {
-		if prevTrack, ok := prevArr[0].(map[string]interface{}); ok {
-			result["prev"] = transformTrack(prevTrack)
+		var track map[string]json.RawMessage
+		if json.Unmarshal(raw, &track) != nil {
+			continue
+		}
+		dropped := false
+		for _, name := range v2TrackFields {
+			if _, ok := track[name]; ok {
+				delete(track, name)
+				dropped = true
+			}
+		}
+		if data, err := json.Marshal(track); dropped && err == nil {
+			fields[field], changed = data, true
 		}
 	}
+	if !changed {
+		return full
+	}
 
-	return result
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return full
+	}
+	return data
 }
 
 func generateETag(data []byte) string {
@@ -3,10 +3,13 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -60,7 +63,7 @@ func TestHandler(t *testing.T) {
 	defer func() { fetchMetadata = originalFetchMetadata }()
 
 	// Override fetchMetadata for testing
-	fetchMetadata = func(param string) ([]byte, error) {
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
 		resp := map[string]interface{}{
 			"stationName": param,
 			"now":         map[string]interface{}{"firstLine": "Test"},
@@ -70,9 +73,7 @@ func TestHandler(t *testing.T) {
 	}
 
 	// Reset cache for this test
-	cacheMutex.Lock()
-	cache = make(map[string]CachedResponse)
-	cacheMutex.Unlock()
+	cache.Purge()
 
 	req, err := http.NewRequest("GET", "/api/metadata/fip_rock", nil)
 	if err != nil {
@@ -92,9 +93,7 @@ func TestHandler(t *testing.T) {
 
 func TestHandlerUnknownStation(t *testing.T) {
 	// Reset cache
-	cacheMutex.Lock()
-	cache = make(map[string]CachedResponse)
-	cacheMutex.Unlock()
+	cache.Purge()
 
 	// Restore original fetchMetadata after test
 	originalFetchMetadata := fetchMetadata
@@ -113,30 +112,109 @@ func TestHandlerUnknownStation(t *testing.T) {
 	router.HandleFunc("/api/metadata/{param}", handler)
 	router.ServeHTTP(rr, req)
 
-	if status := rr.Code; status != http.StatusInternalServerError {
-		t.Errorf("handler should return 500 for unknown station: got %v want %v",
-			status, http.StatusInternalServerError)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("handler should return 404 for unknown station: got %v want %v",
+			status, http.StatusNotFound)
+	}
+
+	p := decodeProblem(t, rr)
+	if p.Type != problemTypeUnknownStation || p.Status != http.StatusNotFound || p.Instance != "/api/metadata/fip_nonexistent" {
+		t.Errorf("unexpected problem body: %+v", p)
+	}
+	if len(p.Stations) != len(registry.Names()) {
+		t.Errorf("expected the valid station names in the problem, got %v", p.Stations)
+	}
+}
+
+// decodeProblem checks for a problem+json response and decodes it
+func decodeProblem(t *testing.T, rr *httptest.ResponseRecorder) problem {
+	t.Helper()
+	if ct := rr.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected application/problem+json, got %q", ct)
+	}
+	var p problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &p); err != nil {
+		t.Fatalf("failed to unmarshal problem: %v", err)
+	}
+	return p
+}
+
+func TestHandlerUpstreamErrors(t *testing.T) {
+	originalBaseURL, originalTimeout, originalRetries, originalUpstream := baseURL, upstreamTimeout, upstreamRetries, upstream
+	defer func() {
+		baseURL, upstreamTimeout, upstreamRetries, upstream = originalBaseURL, originalTimeout, originalRetries, originalUpstream
+	}()
+	upstreamTimeout, upstreamRetries = 50*time.Millisecond, 0
+
+	tests := []struct {
+		name       string
+		upstream   http.HandlerFunc
+		wantStatus int
+		wantType   string
+	}{
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		}, http.StatusGatewayTimeout, problemTypeUpstreamTimeout},
+		{"non-200", func(w http.ResponseWriter, r *http.Request) {
+			http.NotFound(w, r)
+		}, http.StatusBadGateway, problemTypeUpstreamStatus},
+		{"malformed body", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"now":`))
+		}, http.StatusBadGateway, problemTypeUpstreamMalformed},
+		{"null body", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`null`))
+		}, http.StatusBadGateway, problemTypeUpstreamMalformed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(tc.upstream)
+			defer server.Close()
+			baseURL = server.URL
+			upstream = newUpstreamClient()
+
+			cache.Purge()
+
+			rr := httptest.NewRecorder()
+			router := mux.NewRouter()
+			router.HandleFunc("/api/metadata/{param}", handler)
+			router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/metadata/fip_rock", nil))
+
+			if rr.Code != tc.wantStatus {
+				t.Errorf("expected %d, got %d", tc.wantStatus, rr.Code)
+			}
+			p := decodeProblem(t, rr)
+			if p.Type != tc.wantType || p.Status != tc.wantStatus || p.Detail == "" {
+				t.Errorf("unexpected problem body: %+v", p)
+			}
+		})
+	}
+}
+
+func TestProblemForUnclassifiedError(t *testing.T) {
+	p := problemFor(fmt.Errorf("something broke"))
+	if p.Status != http.StatusInternalServerError || p.Type != problemTypeDefault || p.Detail != "something broke" {
+		t.Errorf("unexpected problem for unclassified error: %+v", p)
 	}
 }
 
 func TestGetCachedData(t *testing.T) {
 	// Reset cache before test
-	cacheMutex.Lock()
-	cache = make(map[string]CachedResponse)
-	cacheMutex.Unlock()
+	cache.Purge()
 
 	param := "fip_rock"
 	testData := []byte(`{"stationName":"fip_rock","now":{"firstLine":"Test"}}`)
 
 	// Pre-populate cache with test data
-	cacheMutex.Lock()
-	cache[param] = CachedResponse{
+	cache.Set(param, CachedResponse{
 		Data:     testData,
 		CachedAt: time.Now(),
-	}
-	cacheMutex.Unlock()
+	})
 
-	data, etag, err := getCachedData(param)
+	data, etag, err := getCachedData(context.Background(), param)
 	if err != nil {
 		t.Fatalf("getCachedData returned an error: %v", err)
 	}
@@ -164,7 +242,7 @@ func TestFetchMetadata(t *testing.T) {
 	defer func() { baseURL = originalBaseURL }()
 
 	param := "fip_rock"
-	data, err := fetchMetadata(param)
+	data, err := fetchMetadata(context.Background(), param)
 	if err != nil {
 		t.Fatalf("fetchMetadata returned an error: %v", err)
 	}
@@ -217,70 +295,69 @@ func TestFetchMetadata(t *testing.T) {
 }
 
 func TestTransformTrack(t *testing.T) {
-	raw := map[string]interface{}{
-		"firstLine":  "Song Title",
+	var raw livemetaTrack
+	if err := json.Unmarshal([]byte(`{
+		"firstLine": "Song Title",
 		"secondLine": "Artist Name",
-		"cover":      "abc-123-uuid",
-		"startTime":  float64(1700000000),
-		"endTime":    float64(1700000300),
-		"songUuid":   "song-uuid-456",
+		"cover": "abc-123-uuid",
+		"startTime": 1700000000,
+		"endTime": 1700000300,
+		"songUuid": "song-uuid-456"
+	}`), &raw); err != nil {
+		t.Fatal(err)
 	}
 
 	result := transformTrack(raw)
 
 	// firstLine should be object with title
-	fl := result["firstLine"].(map[string]interface{})
-	if fl["title"] != "Song Title" {
-		t.Errorf("expected firstLine.title = 'Song Title', got %v", fl["title"])
+	if result.FirstLine == nil || result.FirstLine.Title != "Song Title" {
+		t.Errorf("expected firstLine.title = 'Song Title', got %+v", result.FirstLine)
 	}
 
 	// secondLine should be object with title
-	sl := result["secondLine"].(map[string]interface{})
-	if sl["title"] != "Artist Name" {
-		t.Errorf("expected secondLine.title = 'Artist Name', got %v", sl["title"])
+	if result.SecondLine == nil || result.SecondLine.Title != "Artist Name" {
+		t.Errorf("expected secondLine.title = 'Artist Name', got %+v", result.SecondLine)
 	}
 
 	// visuals.card.src should be constructed from cover
-	visuals := result["visuals"].(map[string]interface{})
-	card := visuals["card"].(map[string]interface{})
-	if card["src"] != visualBaseURL+"/abc-123-uuid" {
-		t.Errorf("unexpected visuals.card.src: %v", card["src"])
+	if result.Visuals == nil || result.Visuals.Card.Src != visualBaseURL+"/abc-123-uuid" {
+		t.Errorf("unexpected visuals: %+v", result.Visuals)
 	}
 
 	// Timing fields preserved
-	if result["startTime"] != float64(1700000000) {
-		t.Errorf("startTime not preserved: %v", result["startTime"])
+	if result.StartTime == nil || *result.StartTime != 1700000000 {
+		t.Errorf("startTime not preserved: %v", result.StartTime)
+	}
+	if result.SongUUID != "song-uuid-456" {
+		t.Errorf("songUuid not preserved: %v", result.SongUUID)
 	}
-	if result["songUuid"] != "song-uuid-456" {
-		t.Errorf("songUuid not preserved: %v", result["songUuid"])
+
+	// Missing lines and covers are left out rather than served empty
+	data, _ := json.Marshal(transformTrack(livemetaTrack{}))
+	if string(data) != "{}" {
+		t.Errorf("expected an empty track to marshal to {}, got %s", data)
 	}
 }
 
 func TestTransformResponse(t *testing.T) {
-	raw := map[string]interface{}{
-		"now": map[string]interface{}{
-			"firstLine":  "Current Song",
-			"secondLine": "Current Artist",
-			"cover":      "now-uuid",
-		},
-		"next": []interface{}{
-			map[string]interface{}{
-				"firstLine":  "Next Song",
-				"secondLine": "Next Artist",
-				"cover":      "next-uuid",
-			},
-		},
-		"prev": []interface{}{
-			map[string]interface{}{
-				"firstLine":  "Prev Song",
-				"secondLine": "Prev Artist",
-				"cover":      "prev-uuid",
-			},
-		},
-		"delayToRefresh": float64(60000),
+	raw, err := decodeLivemeta("fip_rock", []byte(`{
+		"now": {"firstLine": "Current Song", "secondLine": "Current Artist", "cover": "now-uuid"},
+		"next": [{"firstLine": "Next Song", "secondLine": "Next Artist", "cover": "next-uuid"}],
+		"prev": [{"firstLine": "Prev Song", "secondLine": "Prev Artist", "cover": "prev-uuid"}],
+		"delayToRefresh": 60000
+	}`), decodeStrict)
+	if err != nil {
+		t.Fatal(err)
 	}
 
-	result := transformResponse(raw, "fip_rock")
+	data, err := json.Marshal(transformResponse(raw, "fip_rock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal(v1Payload(data), &result); err != nil {
+		t.Fatal(err)
+	}
 
 	if result["stationName"] != "fip_rock" {
 		t.Errorf("expected stationName fip_rock, got %v", result["stationName"])
@@ -312,6 +389,93 @@ func TestTransformResponse(t *testing.T) {
 	}
 }
 
+func TestTransformResponseFull(t *testing.T) {
+	raw, err := decodeLivemeta("fip_jazz", []byte(`{
+		"now": {"firstLine": "Current Song"},
+		"next": [{"firstLine": "Next 1"}, {"firstLine": "Next 2"}, {"firstLine": "Next 3"}],
+		"prev": []
+	}`), decodeStrict)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := transformResponse(raw, "fip_jazz")
+	if len(result.Next) != 3 || result.Next[2].FirstLine.Title != "Next 3" {
+		t.Errorf("expected every next track to be transformed, got %+v", result.Next)
+	}
+
+	// Empty arrays are served as [] and dropped from the v1 shape
+	data, _ := json.Marshal(result)
+	if !strings.Contains(string(data), `"prev":[]`) {
+		t.Errorf("expected an empty prev array, got %s", data)
+	}
+	v1 := v1Payload(data)
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(v1, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["prev"]; ok {
+		t.Errorf("expected v1 payload without prev, got %s", v1)
+	}
+	if string(fields["next"]) != `{"firstLine":{"title":"Next 1"}}` {
+		t.Errorf("expected v1 next to be the first track, got %s", fields["next"])
+	}
+
+	// Payloads already in the v1 shape pass through
+	if got := v1Payload(v1); string(got) != string(v1) {
+		t.Errorf("expected v1 payload unchanged, got %s", got)
+	}
+}
+
+func TestHandlerFull(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"fip","now":{"songUuid":"now"},"next":[{"songUuid":"n1"},{"songUuid":"n2"}],"prev":[{"songUuid":"p1"}]}`), nil
+	}
+	cache.Purge()
+
+	get := func(url string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", url, nil)
+		req = mux.SetURLVars(req, map[string]string{"param": "fip"})
+		handler(rr, req)
+		return rr
+	}
+
+	var v1 struct {
+		Next struct {
+			SongUUID string `json:"songUuid"`
+		} `json:"next"`
+	}
+	rr := get("/api/metadata/fip")
+	if err := json.Unmarshal(rr.Body.Bytes(), &v1); err != nil || v1.Next.SongUUID != "n1" {
+		t.Errorf("expected v1 next to be the first track, got %s", rr.Body.String())
+	}
+	v1ETag := rr.Header().Get("ETag")
+
+	var full struct {
+		Next []struct {
+			SongUUID string `json:"songUuid"`
+		} `json:"next"`
+		Prev []struct {
+			SongUUID string `json:"songUuid"`
+		} `json:"prev"`
+	}
+	rr = get("/api/metadata/fip?full=1")
+	if err := json.Unmarshal(rr.Body.Bytes(), &full); err != nil || len(full.Next) != 2 || len(full.Prev) != 1 {
+		t.Errorf("expected full next/prev arrays, got %s", rr.Body.String())
+	}
+	if etag := rr.Header().Get("ETag"); etag == "" || etag == v1ETag {
+		t.Errorf("expected the full payload to have its own ETag, got %q", etag)
+	}
+
+	if rr := get("/api/metadata/fip?full=maybe"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an invalid full parameter, got %d", rr.Code)
+	}
+}
+
 func TestStationNames(t *testing.T) {
 	stations := []string{
 		"fip_reggae", "fip_pop", "fip_metal", "fip_hiphop", "fip_rock",
@@ -329,7 +493,7 @@ func TestStationNames(t *testing.T) {
 			baseURL = ts.server.URL + "/livemeta/live"
 			defer func() { baseURL = originalBaseURL }()
 
-			data, err := fetchMetadata(station)
+			data, err := fetchMetadata(context.Background(), station)
 			if err != nil {
 				t.Fatalf("fetchMetadata returned an error for %s: %v", station, err)
 			}
@@ -391,3 +555,313 @@ func TestBuildURL(t *testing.T) {
 		t.Errorf("expected URL %s, got %s", expected, url)
 	}
 }
+
+func TestCachedResponseFresh(t *testing.T) {
+	now := time.Now()
+
+	if !(CachedResponse{CachedAt: now}).fresh(now) {
+		t.Error("entry cached just now should be fresh")
+	}
+	if (CachedResponse{CachedAt: now.Add(-cacheMinTTL)}).fresh(now) {
+		t.Error("entry older than cacheMinTTL should not be fresh")
+	}
+
+	// An explicit expiry overrides cacheMinTTL in both directions
+	if !(CachedResponse{CachedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Second)}).fresh(now) {
+		t.Error("entry before its ExpiresAt should be fresh")
+	}
+	if (CachedResponse{CachedAt: now, ExpiresAt: now}).fresh(now) {
+		t.Error("entry at its ExpiresAt should not be fresh")
+	}
+}
+
+func TestEntryTTL(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name string
+		data string
+		want time.Duration
+	}{
+		{"delayToRefresh first", `{"delayToRefresh": 30000, "now": {"endTime": 1700000100}}`, 30 * time.Second},
+		{"track end first", `{"delayToRefresh": 90000, "now": {"endTime": 1700000045}}`, 45 * time.Second},
+		{"floor", `{"delayToRefresh": 1000}`, 5 * time.Second},
+		{"ceiling", `{"delayToRefresh": 600000}`, 2 * time.Minute},
+		{"nothing known", `{"stationName": "fip"}`, 5 * time.Second},
+		{"not a payload", `not json`, 5 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := entryTTL([]byte(tc.data), now, 5*time.Second, 2*time.Minute); got != tc.want {
+				t.Errorf("entryTTL() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestGetCachedDataCoalescesConcurrentMisses is the offline counterpart of the
+// ConcurrentRequests integration test: many concurrent misses make one upstream call.
+func TestGetCachedDataCoalescesConcurrentMisses(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	originalTTL := cacheMinTTL
+	defer func() {
+		fetchMetadata = originalFetchMetadata
+		cacheMinTTL = originalTTL
+	}()
+	cacheMinTTL = time.Minute
+
+	cache.Purge()
+
+	var mu sync.Mutex
+	calls := map[string]int{}
+	release := make(chan struct{})
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		mu.Lock()
+		calls[param]++
+		mu.Unlock()
+		<-release
+		return []byte(`{"stationName":"` + param + `"}`), nil
+	}
+
+	const concurrentRequests = 20
+	var wg sync.WaitGroup
+	for i := 0; i < concurrentRequests; i++ {
+		for _, station := range []string{"fip", "fip_rock"} {
+			wg.Add(1)
+			go func(station string) {
+				defer wg.Done()
+				data, _, err := getCachedData(context.Background(), station)
+				if err != nil {
+					t.Errorf("getCachedData returned an error: %v", err)
+					return
+				}
+				if err := validateJSONResponse(data); err != nil {
+					t.Errorf("invalid JSON for %s: %v", station, err)
+				}
+			}(station)
+		}
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	// Requests within the TTL are served from cache
+	if _, _, err := getCachedData(context.Background(), "fip"); err != nil {
+		t.Fatalf("getCachedData returned an error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, station := range []string{"fip", "fip_rock"} {
+		if calls[station] != 1 {
+			t.Errorf("expected 1 upstream call for %s, got %d", station, calls[station])
+		}
+	}
+}
+
+func TestGetCachedDataSlowStationDoesNotBlockOthers(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	cache.Purge()
+
+	slow := make(chan struct{})
+	slowDone := make(chan struct{})
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		if param == "fip_jazz" {
+			<-slow
+		}
+		return []byte(`{"stationName":"` + param + `"}`), nil
+	}
+
+	go func() {
+		defer close(slowDone)
+		if _, _, err := getCachedData(context.Background(), "fip_jazz"); err != nil {
+			t.Errorf("getCachedData returned an error: %v", err)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if _, _, err := getCachedData(context.Background(), "fip_rock"); err != nil {
+			t.Errorf("getCachedData returned an error: %v", err)
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Error("fip_rock request was blocked by slow fip_jazz fetch")
+	}
+	close(slow)
+	<-slowDone
+}
+
+// seedExpiredEntry resets the cache to a single entry for param that expired age ago
+func seedExpiredEntry(param string, data []byte, age time.Duration) {
+	cache.Purge()
+	now := time.Now()
+	cache.Set(param, CachedResponse{Data: data, CachedAt: now.Add(-age), ExpiresAt: now.Add(-age)})
+}
+
+func TestHandlerServesStaleOnUpstreamError(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return nil, fmt.Errorf("upstream down")
+	}
+	seedExpiredEntry("fip_rock", []byte(`{"stationName":"fip_rock"}`), staleWhileRevalidate+time.Minute)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/metadata/{param}", handler)
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/metadata/fip_rock", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected stale 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if w := rr.Header().Get("Warning"); w != warningRevalidationFailed {
+		t.Errorf("expected Warning %q, got %q", warningRevalidationFailed, w)
+	}
+	if age := rr.Header().Get("Age"); age == "" || age == "0" {
+		t.Errorf("expected a positive Age header, got %q", age)
+	}
+
+	var resp map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if resp["stale"] != true || resp["stationName"] != "fip_rock" {
+		t.Errorf("expected stale fip_rock payload, got %v", resp)
+	}
+}
+
+func TestHandlerErrorsBeyondMaxStaleness(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return nil, fmt.Errorf("upstream down")
+	}
+	seedExpiredEntry("fip_rock", []byte(`{"stationName":"fip_rock"}`), staleIfError+time.Minute)
+
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/metadata/{param}", handler)
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/metadata/fip_rock", nil))
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500 beyond max staleness, got %d", rr.Code)
+	}
+
+	if _, found := cache.Peek("fip_rock"); found {
+		t.Error("expected entry beyond max staleness to be dropped")
+	}
+}
+
+func TestLookupCachedDataStaleWhileRevalidate(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	refreshed := make(chan struct{})
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		defer close(refreshed)
+		return []byte(`{"stationName":"fip","now":{"songUuid":"new"}}`), nil
+	}
+	seedExpiredEntry("fip", []byte(`{"stationName":"fip","now":{"songUuid":"old"}}`), time.Second)
+
+	result, err := lookupCachedData(context.Background(), "fip")
+	if err != nil {
+		t.Fatalf("lookupCachedData returned an error: %v", err)
+	}
+	if !result.Stale || result.Warning != warningStale {
+		t.Errorf("expected stale result with %q, got %+v", warningStale, result)
+	}
+	if !strings.Contains(string(result.Data), `"old"`) || !strings.Contains(string(result.Data), `"stale":true`) {
+		t.Errorf("expected old payload marked stale, got %s", result.Data)
+	}
+
+	select {
+	case <-refreshed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a background refresh")
+	}
+	fetchGroup.Do(context.Background(), "fip", func(context.Context) ([]byte, error) { return nil, nil }) // wait for the refresh to store its result
+
+	result, err = lookupCachedData(context.Background(), "fip")
+	if err != nil {
+		t.Fatalf("lookupCachedData returned an error: %v", err)
+	}
+	if result.Stale || !strings.Contains(string(result.Data), `"new"`) {
+		t.Errorf("expected refreshed fresh payload, got %+v", result)
+	}
+}
+
+func TestMarkStale(t *testing.T) {
+	marked := markStale([]byte(`{"stationName":"fip"}`))
+	var resp map[string]interface{}
+	if err := json.Unmarshal(marked, &resp); err != nil {
+		t.Fatalf("marked payload is not valid JSON: %v", err)
+	}
+	if resp["stale"] != true || resp["stationName"] != "fip" {
+		t.Errorf("unexpected marked payload: %s", marked)
+	}
+
+	if got := markStale([]byte(`[1,2]`)); string(got) != `[1,2]` {
+		t.Errorf("expected non-object payload unchanged, got %s", got)
+	}
+}
+
+func TestHandlerAbandonedRequestCancelsFetch(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	started, cancelled := make(chan struct{}), make(chan struct{})
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	}
+	cache.Purge()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest("GET", "/api/metadata/fip_jazz", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/metadata/{param}", handler)
+
+	done := make(chan struct{})
+	go func() {
+		router.ServeHTTP(rr, req)
+		close(done)
+	}()
+
+	<-started
+	cancel()
+	for _, ch := range []chan struct{}{done, cancelled} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatal("abandoned request did not stop the upstream fetch")
+		}
+	}
+}
+
+func TestAdminRoutesOnlyOnAdminRouter(t *testing.T) {
+	admin := mux.NewRouter()
+	registerAdmin(admin)
+
+	for _, path := range []string{"/admin/breakers", "/admin/schema", "/admin/cache"} {
+		rr := httptest.NewRecorder()
+		admin.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected %s on the admin router, got %d", path, rr.Code)
+		}
+		if rr := serveAPI("GET", path); rr.Code != http.StatusNotFound {
+			t.Errorf("expected %s to be absent from the public API, got %d", path, rr.Code)
+		}
+	}
+}
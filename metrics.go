@@ -0,0 +1,71 @@
+// ABOUTME: Prometheus metrics for the cache, upstream calls, the metadata handler and streams.
+// ABOUTME: Exposed in the text exposition format on GET /metrics.
+package main
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	cacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_cache_hits_total",
+		Help: "Lookups answered from the cache without an upstream call, including stale entries served while revalidating.",
+	}, []string{"station"})
+
+	cacheMisses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_cache_misses_total",
+		Help: "Lookups that had to wait for an upstream fetch because the entry was missing or expired.",
+	}, []string{"station"})
+
+	cacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_cache_evictions_total",
+		Help: "Entries removed from the cache, by reason: \"lru\" when over the size limits, \"expired\" by the janitor, \"removed\" after a failed refresh.",
+	}, []string{"station", "reason"})
+
+	cacheEntries = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fip_cache_entries",
+		Help: "Entries in the cache.",
+	}, func() float64 { return float64(cache.Stats().Entries) })
+
+	cacheBytes = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "fip_cache_bytes",
+		Help: "Approximate payload bytes held by the cache.",
+	}, func() float64 { return float64(cache.Stats().Bytes) })
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "fip_upstream_request_duration_seconds",
+		Help:    "Latency of single upstream attempts, including reading the body.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	}, []string{"station_id"})
+
+	upstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_upstream_requests_total",
+		Help: "Upstream attempts by HTTP status code, or \"timeout\"/\"error\" when no response arrived.",
+	}, []string{"station_id", "code"})
+
+	metadataResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_metadata_responses_total",
+		Help: "Responses from /api/metadata by status code; 304 means the client's ETag matched.",
+	}, []string{"code"})
+
+	streamSubscribers = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "fip_stream_subscribers",
+		Help: "Active SSE and WebSocket subscriptions.",
+	}, []string{"station"})
+
+	decodeErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "fip_decode_errors_total",
+		Help: "Upstream payloads, or fields within them, that did not have the expected shape.",
+	}, []string{"station", "field"})
+)
+
+// stationIDLabel returns the Radio France station ID used to label upstream metrics
+func stationIDLabel(station string) string {
+	if config, ok := registry.Lookup(station); ok {
+		return strconv.Itoa(config.ID)
+	}
+	return "unknown"
+}
@@ -0,0 +1,142 @@
+// ABOUTME: Unit tests for the Prometheus metrics.
+// ABOUTME: Reads counters directly with testutil and scrapes /metrics once.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCacheMetrics(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"fip_reggae"}`), nil
+	}
+	cache.Purge()
+
+	hits := testutil.ToFloat64(cacheHits.WithLabelValues("fip_reggae"))
+	misses := testutil.ToFloat64(cacheMisses.WithLabelValues("fip_reggae"))
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := getCachedData(context.Background(), "fip_reggae"); err != nil {
+			t.Fatalf("getCachedData returned an error: %v", err)
+		}
+	}
+
+	if got := testutil.ToFloat64(cacheMisses.WithLabelValues("fip_reggae")) - misses; got != 1 {
+		t.Errorf("expected 1 miss, got %v", got)
+	}
+	if got := testutil.ToFloat64(cacheHits.WithLabelValues("fip_reggae")) - hits; got != 1 {
+		t.Errorf("expected 1 hit, got %v", got)
+	}
+}
+
+func TestMetadataResponseMetrics(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"fip_world"}`), nil
+	}
+	cache.Purge()
+
+	ok := testutil.ToFloat64(metadataResponses.WithLabelValues("200"))
+	notModified := testutil.ToFloat64(metadataResponses.WithLabelValues("304"))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/metadata/{param}", handler)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/metadata/fip_world", nil))
+
+	req := httptest.NewRequest("GET", "/api/metadata/fip_world", nil)
+	req.Header.Set("If-None-Match", rr.Header().Get("ETag"))
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(metadataResponses.WithLabelValues("200")) - ok; got != 1 {
+		t.Errorf("expected one 200, got %v", got)
+	}
+	if got := testutil.ToFloat64(metadataResponses.WithLabelValues("304")) - notModified; got != 1 {
+		t.Errorf("expected one 304, got %v", got)
+	}
+}
+
+func TestUpstreamMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "gone", http.StatusGone)
+	}))
+	defer server.Close()
+
+	before := testutil.ToFloat64(upstreamRequests.WithLabelValues("65", "410"))
+	testUpstreamClient(t, 0, 5).Fetch(context.Background(), "fip_jazz", server.URL)
+	if got := testutil.ToFloat64(upstreamRequests.WithLabelValues("65", "410")) - before; got != 1 {
+		t.Errorf("expected one 410 for station 65, got %v", got)
+	}
+}
+
+func TestDecodeErrorMetrics(t *testing.T) {
+	before := testutil.ToFloat64(decodeErrors.WithLabelValues("fip_electro", "next"))
+	decodeLivemeta("fip_electro", []byte(`{"next":"not an array","prev":[]}`), decodeLenient)
+	if got := testutil.ToFloat64(decodeErrors.WithLabelValues("fip_electro", "next")) - before; got != 1 {
+		t.Errorf("expected one decode error for next, got %v", got)
+	}
+}
+
+func TestStreamSubscriberMetrics(t *testing.T) {
+	stubTrackSequence(t)
+	hub := newStreamHub()
+	defer hub.Wait()
+
+	before := testutil.ToFloat64(streamSubscribers.WithLabelValues("fip_pop"))
+	_, unsubscribe := hub.Subscribe("fip_pop")
+	if got := testutil.ToFloat64(streamSubscribers.WithLabelValues("fip_pop")) - before; got != 1 {
+		t.Errorf("expected one active subscriber, got %v", got)
+	}
+	unsubscribe()
+	if got := testutil.ToFloat64(streamSubscribers.WithLabelValues("fip_pop")); got != before {
+		t.Errorf("expected subscriber gauge back at %v, got %v", before, got)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	metadataResponses.WithLabelValues("200")
+
+	rr := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "fip_metadata_responses_total") {
+		t.Errorf("expected fip metrics in exposition, got %d", rr.Code)
+	}
+}
+
+func TestUnknownStationsAddNoSeries(t *testing.T) {
+	cache.Purge()
+	misses, hits := testutil.CollectAndCount(cacheMisses), testutil.CollectAndCount(cacheHits)
+	lookups := cache.Stats().Misses
+
+	for i := 0; i < 5; i++ {
+		rr := serveAPI("GET", fmt.Sprintf("/api/v1/metadata/junk_%d", i))
+		if rr.Code != http.StatusNotFound {
+			t.Fatalf("expected 404 for an unknown station, got %d", rr.Code)
+		}
+	}
+	if rr := serveAPI("GET", "/api/v1/metadata?stations=junk_a,junk_b,junk_c"); rr.Code != http.StatusOK {
+		t.Fatalf("expected the batch to report per-station errors, got %d", rr.Code)
+	}
+
+	if got := testutil.CollectAndCount(cacheMisses); got != misses {
+		t.Errorf("expected no new miss series, went from %d to %d", misses, got)
+	}
+	if got := testutil.CollectAndCount(cacheHits); got != hits {
+		t.Errorf("expected no new hit series, went from %d to %d", hits, got)
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Misses != lookups {
+		t.Errorf("expected unknown stations to leave the cache untouched, got %+v", stats)
+	}
+}
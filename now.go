@@ -0,0 +1,56 @@
+// ABOUTME: Overview of what every station is playing, for channel-surfing views.
+// ABOUTME: Served from the cache with progress through the current track computed server-side.
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// nowPlaying is one station in GET /api/v1/now. The track fields are absent while
+// nothing is cached for the station.
+type nowPlaying struct {
+	Station string `json:"station"`
+	*trackSummary
+	Progress *float64 `json:"progress,omitempty"` // fraction of the track played, 0 to 1
+}
+
+// trackProgress reports how far through a track now is, from 0 to 1. It is false when
+// the track has no usable start and end time.
+func trackProgress(track *trackSummary, now time.Time) (float64, bool) {
+	if track == nil || track.StartTime <= 0 || track.EndTime <= track.StartTime {
+		return 0, false
+	}
+	elapsed := now.Sub(time.Unix(track.StartTime, 0)).Seconds()
+	progress := elapsed / float64(track.EndTime-track.StartTime)
+	if progress < 0 {
+		return 0, true
+	}
+	if progress > 1 {
+		return 1, true
+	}
+	return progress, true
+}
+
+// nowHandler serves GET /api/v1/now. Like /api/v1/stations it only reads the cache, which
+// the prefetcher keeps warm, so it never fans out to upstream.
+func nowHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	names := registry.Names()
+	stations := make([]nowPlaying, 0, len(names))
+	for _, name := range names {
+		entry := nowPlaying{Station: name}
+		if data, ok := peekCachedData(name); ok {
+			entry.trackSummary = summarizeTrack(data)
+			if progress, ok := trackProgress(entry.trackSummary, now); ok {
+				entry.Progress = &progress
+			}
+		}
+		stations = append(stations, entry)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"serverTime": now.UTC().Format(time.RFC3339),
+		"stations":   stations,
+	})
+}
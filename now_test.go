@@ -0,0 +1,85 @@
+// ABOUTME: Unit tests for the now playing overview endpoint.
+// ABOUTME: Seeds the cache directly so no upstream calls are made.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTrackProgress(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	track := &trackSummary{StartTime: start.Unix(), EndTime: start.Add(200 * time.Second).Unix()}
+
+	tests := []struct {
+		name   string
+		track  *trackSummary
+		now    time.Time
+		want   float64
+		wantOK bool
+	}{
+		{"halfway", track, start.Add(100 * time.Second), 0.5, true},
+		{"not started", track, start.Add(-time.Minute), 0, true},
+		{"overrun", track, start.Add(time.Hour), 1, true},
+		{"no times", &trackSummary{Title: "Song"}, start, 0, false},
+		{"no track", nil, start, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := trackProgress(tc.track, tc.now)
+			if got != tc.want || ok != tc.wantOK {
+				t.Errorf("trackProgress() = %v, %v, want %v, %v", got, ok, tc.want, tc.wantOK)
+			}
+		})
+	}
+}
+
+func TestNowHandler(t *testing.T) {
+	originalRegistry := registry
+	defer func() { registry = originalRegistry }()
+	registry = newStationRegistry(map[string]stationConfig{
+		"fip":      {ID: 7, Format: "webrf_fip_player"},
+		"fip_rock": {ID: 64, Format: "webrf_webradio_player"},
+	})
+
+	start := time.Now().Add(-time.Minute).Unix()
+	payload := fmt.Sprintf(`{"stationName":"fip","now":{"firstLine":{"title":"Song"},"secondLine":{"title":"Artist"},`+
+		`"visuals":{"card":{"src":"https://img/cover"}},"startTime":%d,"endTime":%d}}`, start, start+240)
+	cache.Purge()
+	cache.Set("fip", CachedResponse{Data: []byte(payload), CachedAt: time.Now()})
+
+	rr := serveAPI("GET", "/api/v1/now")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var resp struct {
+		ServerTime string `json:"serverTime"`
+		Stations   []map[string]interface{}
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if _, err := time.Parse(time.RFC3339, resp.ServerTime); err != nil {
+		t.Errorf("expected an RFC 3339 serverTime, got %q", resp.ServerTime)
+	}
+	if len(resp.Stations) != 2 {
+		t.Fatalf("expected every station, got %v", resp.Stations)
+	}
+
+	fip := resp.Stations[0]
+	if fip["station"] != "fip" || fip["title"] != "Song" || fip["artist"] != "Artist" || fip["cover"] != "https://img/cover" ||
+		fip["startTime"] != float64(start) || fip["endTime"] != float64(start+240) {
+		t.Errorf("unexpected fip entry: %v", fip)
+	}
+	if progress, ok := fip["progress"].(float64); !ok || progress < 0.2 || progress > 0.3 {
+		t.Errorf("expected progress about a quarter through, got %v", fip["progress"])
+	}
+
+	// Nothing cached: the station is listed without a track
+	if rock := resp.Stations[1]; rock["station"] != "fip_rock" || len(rock) != 1 {
+		t.Errorf("expected a bare fip_rock entry, got %v", rock)
+	}
+}
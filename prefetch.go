@@ -0,0 +1,176 @@
+// ABOUTME: Background prefetcher that keeps every station's cache entry warm.
+// ABOUTME: Each station refreshes on its own timer derived from delayToRefresh and the current track's end.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	minPollInterval = 5 * time.Second // Lower bound between refreshes of one station
+	maxPollInterval = 2 * time.Minute // Upper bound between refreshes of one station
+
+	// prefetchGrace keeps a prefetched entry fresh slightly past its scheduled refresh,
+	// so requests arriving while the refresh is in flight are still served from memory
+	prefetchGrace = 5 * time.Second
+	// prefetchJitter spreads the initial fetches so all stations don't hit upstream at once
+	prefetchJitter = 2 * time.Second
+	// prefetchReconcileInterval is how often the prefetcher picks up added or removed stations
+	prefetchReconcileInterval = time.Minute
+)
+
+// payloadSummary is the subset of a transformed payload needed to schedule refreshes
+type payloadSummary struct {
+	DelayToRefresh float64 `json:"delayToRefresh"`
+	Now            struct {
+		SongUUID  string  `json:"songUuid"`
+		StartTime float64 `json:"startTime"`
+		EndTime   float64 `json:"endTime"`
+	} `json:"now"`
+}
+
+func summarizePayload(data []byte) (payloadSummary, error) {
+	var summary payloadSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		return summary, fmt.Errorf("error decoding payload summary: %v", err)
+	}
+	return summary, nil
+}
+
+// untilRefresh is how long a payload stays current: until the upstream delayToRefresh
+// (milliseconds) or the end of the current track, whichever comes first. Zero when neither is known.
+func untilRefresh(summary payloadSummary, now time.Time) time.Duration {
+	delay := time.Duration(summary.DelayToRefresh) * time.Millisecond
+	if summary.Now.EndTime > 0 {
+		untilEnd := time.Unix(int64(summary.Now.EndTime), 0).Sub(now)
+		if untilEnd > 0 && (delay <= 0 || untilEnd < delay) {
+			delay = untilEnd
+		}
+	}
+	return delay
+}
+
+// refreshDelay decides when a station should next be fetched: untilRefresh bounded by
+// minPollInterval and maxPollInterval
+func refreshDelay(summary payloadSummary, now time.Time) time.Duration {
+	return clampDuration(untilRefresh(summary, now), minPollInterval, maxPollInterval)
+}
+
+// clampDuration bounds d to [lo, hi]
+func clampDuration(d, lo, hi time.Duration) time.Duration {
+	if d < lo {
+		return lo
+	}
+	if d > hi {
+		return hi
+	}
+	return d
+}
+
+// payloadRefreshDelay is refreshDelay for a raw payload, falling back to minPollInterval
+// when the payload cannot be summarized
+func payloadRefreshDelay(data []byte, now time.Time) time.Duration {
+	summary, err := summarizePayload(data)
+	if err != nil {
+		return minPollInterval
+	}
+	return refreshDelay(summary, now)
+}
+
+// refreshStation fetches a station and stores the result with an expiry matching its next
+// scheduled refresh, kept within cacheMinTTL and cacheMaxTTL. It returns how long to wait
+// before refreshing again, which is brought forward to before the entry expires when
+// cacheMaxTTL is shorter than the payload asks for.
+func refreshStation(ctx context.Context, station string) time.Duration {
+	// Share the fetch with any request that misses on this station at the same moment
+	fetch := fetchMetadata
+	minTTL, maxTTL := cacheMinTTL, cacheMaxTTL
+	data, _, err := fetchGroup.Do(ctx, station, func(ctx context.Context) ([]byte, error) {
+		data, err := fetch(ctx, station)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		ttl := clampDuration(payloadRefreshDelay(data, now)+prefetchGrace, minTTL, maxTTL)
+		expiresAt := now.Add(ttl)
+		cache.Set(station, newCachedResponse(data, now, expiresAt))
+		return data, nil
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "error prefetching station", "station", station, "error", err)
+		return minPollInterval
+	}
+
+	delay := payloadRefreshDelay(data, time.Now())
+	if limit := maxTTL - prefetchGrace; delay > limit {
+		delay = max(limit, minPollInterval)
+	}
+	slog.DebugContext(ctx, "prefetched station", "station", station, "next_refresh", delay)
+	return delay
+}
+
+// runPrefetcher refreshes every station on its own schedule until ctx is cancelled.
+// The station list is re-read every prefetchReconcileInterval so stations discovered
+// later are picked up and removed stations stop being fetched.
+func runPrefetcher(ctx context.Context, stations func() []string) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	running := make(map[string]context.CancelFunc)
+	reconcile := func() {
+		wanted := make(map[string]bool)
+		for _, station := range stations() {
+			wanted[station] = true
+			if _, ok := running[station]; ok {
+				continue
+			}
+			stationCtx, cancel := context.WithCancel(ctx)
+			running[station] = cancel
+			wg.Add(1)
+			go func(station string) {
+				defer wg.Done()
+				prefetchStation(stationCtx, station)
+			}(station)
+		}
+		for station, cancel := range running {
+			if !wanted[station] {
+				slog.Info("stopped prefetching removed station", "station", station)
+				cancel()
+				delete(running, station)
+			}
+		}
+	}
+
+	ticker := time.NewTicker(prefetchReconcileInterval)
+	defer ticker.Stop()
+	for {
+		reconcile()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prefetchStation refreshes one station until ctx is cancelled
+func prefetchStation(ctx context.Context, station string) {
+	delay := time.Duration(rand.Int63n(int64(prefetchJitter) + 1))
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		delay = refreshStation(ctx, station)
+	}
+}
@@ -0,0 +1,167 @@
+// ABOUTME: Unit tests for the background prefetcher and refresh scheduling.
+// ABOUTME: Stubs fetchMetadata so no upstream calls are made.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRefreshDelay(t *testing.T) {
+	originalMin, originalMax := minPollInterval, maxPollInterval
+	defer func() { minPollInterval, maxPollInterval = originalMin, originalMax }()
+	minPollInterval, maxPollInterval = 5*time.Second, 2*time.Minute
+
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name           string
+		delayToRefresh float64
+		endTime        float64
+		want           time.Duration
+	}{
+		{"no hints", 0, 0, 5 * time.Second},
+		{"delayToRefresh only", 30000, 0, 30 * time.Second},
+		{"delayToRefresh capped", 600000, 0, 2 * time.Minute},
+		{"track ends first", 60000, 1700000020, 20 * time.Second},
+		{"delayToRefresh first", 10000, 1700000020, 10 * time.Second},
+		{"track end without delay", 0, 1700000045, 45 * time.Second},
+		{"track already ended", 30000, 1699999990, 30 * time.Second},
+		{"track ends too soon", 30000, 1700000001, 5 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var summary payloadSummary
+			summary.DelayToRefresh = tc.delayToRefresh
+			summary.Now.EndTime = tc.endTime
+			if got := refreshDelay(summary, now); got != tc.want {
+				t.Errorf("refreshDelay() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRefreshStationCachesWithExpiry(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"fip_groove","delayToRefresh":30000}`), nil
+	}
+
+	cache.Purge()
+
+	delay := refreshStation(context.Background(), "fip_groove")
+	if delay != 30*time.Second {
+		t.Errorf("expected next refresh in 30s, got %v", delay)
+	}
+
+	entry, ok := cache.Peek("fip_groove")
+	if !ok {
+		t.Fatal("expected prefetched entry in cache")
+	}
+	if want := entry.CachedAt.Add(delay + prefetchGrace); !entry.ExpiresAt.Equal(want) {
+		t.Errorf("expected entry to expire at %v, got %v", want, entry.ExpiresAt)
+	}
+
+	// A prefetched entry outlives cacheMinTTL and is served without another fetch
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return nil, errors.New("should not be called")
+	}
+	if _, _, err := getCachedData(context.Background(), "fip_groove"); err != nil {
+		t.Errorf("expected prefetched entry to be served from cache, got %v", err)
+	}
+}
+
+func TestRefreshStationRespectsCacheMaxTTL(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	originalMax := cacheMaxTTL
+	defer func() {
+		fetchMetadata = originalFetchMetadata
+		cacheMaxTTL = originalMax
+	}()
+	cacheMaxTTL = 30 * time.Second // below maxPollInterval
+
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"stationName":"fip_nouveautes","delayToRefresh":600000}`), nil
+	}
+	cache.Purge()
+
+	// The next refresh lands before the entry expires, so requests never fall through to upstream
+	if delay := refreshStation(context.Background(), "fip_nouveautes"); delay != 30*time.Second-prefetchGrace {
+		t.Errorf("expected the refresh before the entry expires, got %v", delay)
+	}
+	entry, ok := cache.Peek("fip_nouveautes")
+	if !ok {
+		t.Fatal("expected prefetched entry in cache")
+	}
+	if ttl := entry.ExpiresAt.Sub(entry.CachedAt); ttl != 30*time.Second {
+		t.Errorf("expected the entry to live for cache.max_ttl, got %v", ttl)
+	}
+
+	// The response headers expose the bounded expiry too
+	rr := serveAPI("GET", "/api/v1/metadata/fip_nouveautes")
+	var maxAge int
+	if _, err := fmt.Sscanf(rr.Header().Get("Cache-Control"), "public, max-age=%d", &maxAge); err != nil || maxAge > 30 {
+		t.Errorf("expected max-age of at most 30s, got %q", rr.Header().Get("Cache-Control"))
+	}
+}
+
+func TestRefreshStationErrorRetriesSoon(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return nil, fmt.Errorf("upstream down")
+	}
+
+	if delay := refreshStation(context.Background(), "fip_metal"); delay != minPollInterval {
+		t.Errorf("expected retry after %v, got %v", minPollInterval, delay)
+	}
+}
+
+func TestRunPrefetcher(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	originalJitter := prefetchJitter
+	defer func() {
+		fetchMetadata = originalFetchMetadata
+		prefetchJitter = originalJitter
+	}()
+	prefetchJitter = 0
+
+	var calls int64
+	fetched := make(chan struct{}, 2)
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		atomic.AddInt64(&calls, 1)
+		fetched <- struct{}{}
+		return []byte(`{"stationName":"` + param + `","delayToRefresh":600000}`), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runPrefetcher(ctx, func() []string { return []string{"fip", "fip_pop"} })
+		close(done)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-fetched:
+		case <-time.After(5 * time.Second):
+			t.Fatal("prefetcher did not fetch every station")
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("prefetcher did not stop after context cancellation")
+	}
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Errorf("expected one fetch per station, got %d", n)
+	}
+}
@@ -0,0 +1,85 @@
+// ABOUTME: Request coalescing for upstream fetches.
+// ABOUTME: Concurrent calls for the same key share a single execution and its result.
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// flightGroup coalesces concurrent calls with the same key into one execution.
+// Calls for different keys run independently.
+//
+// An execution is detached from the callers that share it: it runs with a context derived
+// from base, so one caller giving up does not fail the others. It is cancelled once every
+// caller has given up, or when base is cancelled.
+type flightGroup struct {
+	base context.Context // nil means context.Background()
+
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int
+	val     []byte
+	err     error
+}
+
+// Do runs fn for key unless a call for key is already in flight, in which case it waits
+// for that call and returns its result. shared reports whether the result came from another caller.
+// If ctx is done first, Do returns ctx.Err() without waiting for the call to finish.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func(ctx context.Context) ([]byte, error)) (val []byte, shared bool, err error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		base := g.base
+		if base == nil {
+			base = context.Background()
+		}
+		callCtx, cancel := context.WithCancel(base)
+		call = &flightCall{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = call
+		go g.run(callCtx, key, call, fn)
+	}
+	call.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.val, shared, call.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		call.waiters--
+		if call.waiters == 0 {
+			// Nobody wants the result any more; later callers start a fresh call
+			call.cancel()
+			g.forget(key, call)
+		}
+		g.mu.Unlock()
+		return nil, shared, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, call *flightCall, fn func(ctx context.Context) ([]byte, error)) {
+	defer call.cancel()
+
+	call.val, call.err = fn(ctx)
+
+	g.mu.Lock()
+	g.forget(key, call)
+	g.mu.Unlock()
+	close(call.done)
+}
+
+// forget removes call from the group if it is still the in-flight call for key. Callers hold mu.
+func (g *flightGroup) forget(key string, call *flightCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
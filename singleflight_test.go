@@ -0,0 +1,176 @@
+// ABOUTME: Unit tests for the request-coalescing flight group.
+// ABOUTME: Verifies shared execution per key and independence across keys.
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupCoalescesSameKey(t *testing.T) {
+	var g flightGroup
+	var calls int64
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	results := make(chan string, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			val, _, err := g.Do(context.Background(), "fip", func(context.Context) ([]byte, error) {
+				atomic.AddInt64(&calls, 1)
+				<-release
+				return []byte("payload"), nil
+			})
+			if err != nil {
+				t.Errorf("Do returned an error: %v", err)
+			}
+			results <- string(val)
+		}()
+	}
+
+	// Give every goroutine a chance to join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("expected 1 execution, got %d", n)
+	}
+	for val := range results {
+		if val != "payload" {
+			t.Errorf("expected shared payload, got %q", val)
+		}
+	}
+}
+
+func TestFlightGroupSharesErrorsAndForgetsKey(t *testing.T) {
+	var g flightGroup
+
+	_, _, err := g.Do(context.Background(), "fip", func(context.Context) ([]byte, error) { return nil, errors.New("boom") })
+	if err == nil || err.Error() != "boom" {
+		t.Fatalf("expected boom error, got %v", err)
+	}
+
+	// A finished call is not reused
+	val, shared, err := g.Do(context.Background(), "fip", func(context.Context) ([]byte, error) { return []byte("ok"), nil })
+	if err != nil || string(val) != "ok" || shared {
+		t.Errorf("expected fresh execution, got val=%q shared=%v err=%v", val, shared, err)
+	}
+}
+
+func TestFlightGroupIndependentKeys(t *testing.T) {
+	var g flightGroup
+	blocked := make(chan struct{})
+	defer close(blocked)
+
+	go g.Do(context.Background(), "fip_jazz", func(context.Context) ([]byte, error) {
+		<-blocked
+		return nil, nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		g.Do(context.Background(), "fip_rock", func(context.Context) ([]byte, error) { return nil, nil })
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call for fip_rock was blocked by fip_jazz")
+	}
+}
+
+func TestFlightGroupDetachedFromCaller(t *testing.T) {
+	var g flightGroup
+	release := make(chan struct{})
+	fn := func(ctx context.Context) ([]byte, error) {
+		select {
+		case <-release:
+			return []byte("payload"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// The first caller gives up; the call keeps running for the second
+	impatient, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() {
+		_, _, err := g.Do(impatient, "fip", fn)
+		firstDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	secondDone := make(chan []byte, 1)
+	go func() {
+		val, _, _ := g.Do(context.Background(), "fip", fn)
+		secondDone <- val
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled caller to get context.Canceled, got %v", err)
+	}
+
+	close(release)
+	select {
+	case val := <-secondDone:
+		if string(val) != "payload" {
+			t.Errorf("expected the remaining caller to get the payload, got %q", val)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("remaining caller never got a result")
+	}
+}
+
+func TestFlightGroupCancelledWhenAbandoned(t *testing.T) {
+	var g flightGroup
+	cancelled := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go g.Do(ctx, "fip", func(ctx context.Context) ([]byte, error) {
+		<-ctx.Done()
+		close(cancelled)
+		return nil, ctx.Err()
+	})
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("call was not cancelled after its only caller gave up")
+	}
+
+	// A later caller starts a fresh call rather than joining the cancelled one
+	val, shared, err := g.Do(context.Background(), "fip", func(context.Context) ([]byte, error) { return []byte("ok"), nil })
+	if err != nil || shared || string(val) != "ok" {
+		t.Errorf("expected fresh execution, got val=%q shared=%v err=%v", val, shared, err)
+	}
+}
+
+func TestFlightGroupCancelledWithBase(t *testing.T) {
+	base, cancel := context.WithCancel(context.Background())
+	g := flightGroup{base: base}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, _, err := g.Do(context.Background(), "fip", func(ctx context.Context) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected base cancellation to cancel the call, got %v", err)
+	}
+}
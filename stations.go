@@ -0,0 +1,334 @@
+// ABOUTME: Station registry merging a remote station catalogue with the static station list.
+// ABOUTME: The catalogue is refreshed periodically and exposed via GET /api/stations.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	stationSourceStatic  = "static"
+	stationSourceCatalog = "catalog"
+)
+
+var (
+	icecastBaseURL = "https://icecast.radiofrance.fr" // Direct AAC/MP3 streams
+	hlsBaseURL     = "https://stream.radiofrance.fr"  // HLS streams
+
+	catalogURL             = ""               // Station catalogue JSON; empty uses the static list only
+	catalogRefreshInterval = 15 * time.Minute // How often the catalogue is reloaded
+
+	// registry is the live set of stations every handler and background job resolves names against
+	registry = newStationRegistry(stationMap)
+)
+
+// catalogStation is one entry of the station catalogue JSON:
+// {"stations": [{"name": "fip_rock", "id": 64, "format": "webrf_webradio_player"}, ...]}
+// The descriptive fields are optional.
+type catalogStation struct {
+	Name        string `json:"name"`
+	ID          int    `json:"id"`
+	Format      string `json:"format"`
+	DisplayName string `json:"displayName"`
+	Genre       string `json:"genre"`
+	Logo        string `json:"logo"`
+	StreamSlug  string `json:"streamSlug"`
+}
+
+// stationEntry is a station as served by /api/stations
+type stationEntry struct {
+	Name        string        `json:"name"`
+	ID          int           `json:"id"`
+	Format      string        `json:"format"`
+	Source      string        `json:"source"`
+	DisplayName string        `json:"displayName"`
+	Genre       string        `json:"genre,omitempty"`
+	Logo        string        `json:"logo,omitempty"`
+	Streams     []streamURL   `json:"streams"`
+	Now         *trackSummary `json:"now,omitempty"`
+}
+
+// streamURL is one way to listen to a station
+type streamURL struct {
+	Format  string `json:"format"`            // "hls", "aac" or "mp3"
+	Bitrate int    `json:"bitrate,omitempty"` // kbit/s; adaptive for HLS
+	URL     string `json:"url"`
+}
+
+// trackSummary is a flat view of the current track for station pickers
+type trackSummary struct {
+	Title     string `json:"title,omitempty"`
+	Artist    string `json:"artist,omitempty"`
+	Cover     string `json:"cover,omitempty"`
+	SongUUID  string `json:"songUuid,omitempty"`
+	StartTime int64  `json:"startTime,omitempty"`
+	EndTime   int64  `json:"endTime,omitempty"`
+}
+
+// newStationEntry describes a station, filling in display name and stream URLs
+func newStationEntry(name string, station stationConfig, source string) stationEntry {
+	displayName := station.DisplayName
+	if displayName == "" {
+		displayName = name
+	}
+	return stationEntry{
+		Name:        name,
+		ID:          station.ID,
+		Format:      station.Format,
+		Source:      source,
+		DisplayName: displayName,
+		Genre:       station.Genre,
+		Logo:        station.Logo,
+		Streams:     streamURLs(name, station),
+	}
+}
+
+// streamURLs lists the Radio France HLS, AAC and MP3 streams for a station
+func streamURLs(name string, station stationConfig) []streamURL {
+	slug := station.StreamSlug
+	if slug == "" {
+		slug = strings.ReplaceAll(name, "_", "")
+	}
+	return []streamURL{
+		{Format: "hls", URL: fmt.Sprintf("%s/%s/%s.m3u8?id=radiofrance", hlsBaseURL, slug, slug)},
+		{Format: "aac", Bitrate: 192, URL: fmt.Sprintf("%s/%s-hifi.aac", icecastBaseURL, slug)},
+		{Format: "aac", Bitrate: 128, URL: fmt.Sprintf("%s/%s-midfi.aac", icecastBaseURL, slug)},
+		{Format: "mp3", Bitrate: 128, URL: fmt.Sprintf("%s/%s-midfi.mp3", icecastBaseURL, slug)},
+		{Format: "mp3", Bitrate: 32, URL: fmt.Sprintf("%s/%s-lofi.mp3", icecastBaseURL, slug)},
+	}
+}
+
+// summarizeTrack extracts the current track from a transformed payload.
+// It returns nil when the payload has no current track.
+func summarizeTrack(data []byte) *trackSummary {
+	var payload metadataResponse
+	if err := json.Unmarshal(data, &payload); err != nil || payload.Now == nil {
+		return nil
+	}
+
+	now := payload.Now
+	summary := &trackSummary{SongUUID: now.SongUUID}
+	if now.FirstLine != nil {
+		summary.Title = now.FirstLine.Title
+	}
+	if now.SecondLine != nil {
+		summary.Artist = now.SecondLine.Title
+	}
+	if now.Visuals != nil {
+		summary.Cover = now.Visuals.Card.Src
+	}
+	if now.StartTime != nil {
+		summary.StartTime = int64(*now.StartTime)
+	}
+	if now.EndTime != nil {
+		summary.EndTime = int64(*now.EndTime)
+	}
+	return summary
+}
+
+// stationRegistry holds the static stations and the last loaded catalogue.
+// Static stations override catalogue entries with the same name.
+type stationRegistry struct {
+	mu        sync.RWMutex
+	static    map[string]stationConfig
+	catalog   map[string]stationConfig
+	updatedAt time.Time
+}
+
+func newStationRegistry(static map[string]stationConfig) *stationRegistry {
+	r := &stationRegistry{}
+	r.SetStatic(static)
+	return r
+}
+
+// SetStatic replaces the static stations
+func (r *stationRegistry) SetStatic(static map[string]stationConfig) {
+	copied := make(map[string]stationConfig, len(static))
+	for name, station := range static {
+		copied[name] = station
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.static = copied
+}
+
+// SetCatalog replaces the catalogue stations
+func (r *stationRegistry) SetCatalog(catalog map[string]stationConfig) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.catalog = catalog
+	r.updatedAt = time.Now()
+}
+
+// Lookup resolves a station name
+func (r *stationRegistry) Lookup(name string) (stationConfig, bool) {
+	station, _, ok := r.lookup(name)
+	return station, ok
+}
+
+// Entry describes a single station
+func (r *stationRegistry) Entry(name string) (stationEntry, bool) {
+	station, source, ok := r.lookup(name)
+	if !ok {
+		return stationEntry{}, false
+	}
+	return newStationEntry(name, station, source), true
+}
+
+func (r *stationRegistry) lookup(name string) (stationConfig, string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if station, ok := r.static[name]; ok {
+		return station, stationSourceStatic, true
+	}
+	station, ok := r.catalog[name]
+	return station, stationSourceCatalog, ok
+}
+
+// Names returns every station name in sorted order
+func (r *stationRegistry) Names() []string {
+	entries := r.Entries()
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name
+	}
+	return names
+}
+
+// Entries returns every station with its source, sorted by name
+func (r *stationRegistry) Entries() []stationEntry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entries := make([]stationEntry, 0, len(r.static)+len(r.catalog))
+	for name, station := range r.catalog {
+		if _, overridden := r.static[name]; !overridden {
+			entries = append(entries, newStationEntry(name, station, stationSourceCatalog))
+		}
+	}
+	for name, station := range r.static {
+		entries = append(entries, newStationEntry(name, station, stationSourceStatic))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries
+}
+
+// UpdatedAt returns when the catalogue was last loaded; zero if never
+func (r *stationRegistry) UpdatedAt() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.updatedAt
+}
+
+// fetchCatalog downloads and validates the station catalogue. Invalid entries are skipped.
+func fetchCatalog(ctx context.Context, url string) (map[string]stationConfig, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating catalogue request: %v", err)
+	}
+
+	resp, err := upstream.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching catalogue: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 response code for catalogue: %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Stations []catalogStation `json:"stations"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding catalogue: %v", err)
+	}
+
+	catalog := make(map[string]stationConfig, len(body.Stations))
+	for _, s := range body.Stations {
+		if s.Name == "" || s.ID <= 0 || s.Format == "" {
+			slog.Warn("skipping invalid catalogue entry", "entry", fmt.Sprintf("%+v", s))
+			continue
+		}
+		catalog[s.Name] = stationConfig{
+			ID:          s.ID,
+			Format:      s.Format,
+			DisplayName: s.DisplayName,
+			Genre:       s.Genre,
+			Logo:        s.Logo,
+			StreamSlug:  s.StreamSlug,
+		}
+	}
+	return catalog, nil
+}
+
+// runCatalogRefresher loads the catalogue now and then on every interval until ctx is cancelled.
+// A failed load keeps the previous catalogue.
+func runCatalogRefresher(ctx context.Context, url string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		catalog, err := fetchCatalog(ctx, url)
+		if err != nil {
+			slog.ErrorContext(ctx, "error refreshing station catalogue", "error", err)
+		} else {
+			registry.SetCatalog(catalog)
+			slog.InfoContext(ctx, "loaded station catalogue", "stations", len(catalog))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// stationsHandler serves GET /api/stations. The current track comes from whatever is
+// cached, so listing stations never fans out to upstream.
+func stationsHandler(w http.ResponseWriter, r *http.Request) {
+	entries := registry.Entries()
+	for i := range entries {
+		if data, ok := peekCachedData(entries[i].Name); ok {
+			entries[i].Now = summarizeTrack(data)
+		}
+	}
+
+	response := map[string]interface{}{
+		"stations": entries,
+	}
+	if updatedAt := registry.UpdatedAt(); !updatedAt.IsZero() {
+		response["catalogUpdatedAt"] = updatedAt.UTC()
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// stationHandler serves GET /api/stations/{param}, fetching the current track if needed
+func stationHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["param"]
+	entry, ok := registry.Entry(name)
+	if !ok {
+		writeError(w, r, &unknownStationError{Station: name})
+		return
+	}
+
+	if data, _, err := getCachedData(r.Context(), name); err != nil {
+		slog.WarnContext(r.Context(), "error fetching current track", "station", name, "error", err)
+	} else {
+		entry.Now = summarizeTrack(data)
+	}
+	writeJSON(w, http.StatusOK, entry)
+}
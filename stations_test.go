@@ -0,0 +1,255 @@
+// ABOUTME: Unit tests for the station registry, catalogue loading and /api/stations.
+// ABOUTME: Serves catalogue JSON from an httptest server.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestStationRegistryMerge(t *testing.T) {
+	r := newStationRegistry(map[string]stationConfig{
+		"fip": {ID: 7, Format: "webrf_fip_player"},
+	})
+	r.SetCatalog(map[string]stationConfig{
+		"fip":      {ID: 999, Format: "catalog_format"},
+		"fip_rock": {ID: 64, Format: "webrf_webradio_player"},
+	})
+
+	station, ok := r.Lookup("fip")
+	if !ok || station.ID != 7 {
+		t.Errorf("expected static fip to override catalogue, got %+v", station)
+	}
+	station, ok = r.Lookup("fip_rock")
+	if !ok || station.ID != 64 {
+		t.Errorf("expected catalogue fip_rock, got %+v (ok=%v)", station, ok)
+	}
+	if _, ok := r.Lookup("fip_nonexistent"); ok {
+		t.Error("expected unknown station to be missing")
+	}
+
+	entries := r.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 merged stations, got %+v", entries)
+	}
+	if entries[0].Name != "fip" || entries[0].Source != stationSourceStatic {
+		t.Errorf("unexpected first entry: %+v", entries[0])
+	}
+	if entries[1].Name != "fip_rock" || entries[1].Source != stationSourceCatalog {
+		t.Errorf("unexpected second entry: %+v", entries[1])
+	}
+	if r.UpdatedAt().IsZero() {
+		t.Error("expected catalogue update time to be recorded")
+	}
+}
+
+func TestFetchCatalog(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"stations":[
+			{"name":"fip_cultes","id":709,"format":"webrf_webradio_player"},
+			{"name":"fip_new","id":800,"format":"webrf_webradio_player"},
+			{"name":"","id":1,"format":"x"},
+			{"name":"fip_broken","id":0,"format":"x"}
+		]}`))
+	}))
+	defer server.Close()
+
+	catalog, err := fetchCatalog(context.Background(), server.URL+"/stations.json")
+	if err != nil {
+		t.Fatalf("fetchCatalog returned an error: %v", err)
+	}
+	if len(catalog) != 2 || catalog["fip_new"].ID != 800 {
+		t.Errorf("expected 2 valid catalogue stations, got %v", catalog)
+	}
+
+	if _, err := fetchCatalog(context.Background(), server.URL+"/missing"); err == nil {
+		t.Error("expected error for non-200 catalogue response")
+	}
+}
+
+func TestRunCatalogRefresher(t *testing.T) {
+	originalRegistry := registry
+	registry = newStationRegistry(map[string]stationConfig{"fip": {ID: 7, Format: "webrf_fip_player"}})
+	defer func() { registry = originalRegistry }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"stations":[{"name":"fip_new","id":800,"format":"webrf_webradio_player"}]}`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runCatalogRefresher(ctx, server.URL, time.Hour)
+		close(done)
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		if _, ok := registry.Lookup("fip_new"); ok {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("catalogue station was never loaded")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestStationsHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	stationsHandler(rr, httptest.NewRequest("GET", "/api/stations", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+
+	var resp struct {
+		Stations []stationEntry `json:"stations"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(resp.Stations) != len(stationMap) {
+		t.Errorf("expected %d stations, got %d", len(stationMap), len(resp.Stations))
+	}
+	for _, s := range resp.Stations {
+		if s.Name == "fip_rock" && (s.ID != 64 || s.Source != stationSourceStatic) {
+			t.Errorf("unexpected fip_rock entry: %+v", s)
+		}
+	}
+}
+
+func TestRunPrefetcherFollowsStationChanges(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	originalJitter, originalReconcile := prefetchJitter, prefetchReconcileInterval
+	defer func() {
+		fetchMetadata = originalFetchMetadata
+		prefetchJitter, prefetchReconcileInterval = originalJitter, originalReconcile
+	}()
+	prefetchJitter, prefetchReconcileInterval = 0, 10*time.Millisecond
+
+	fetched := make(chan string, 10)
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		fetched <- param
+		return []byte(`{"delayToRefresh":600000}`), nil
+	}
+
+	stations := make(chan []string, 1)
+	stations <- []string{"fip"}
+	current := []string{"fip"}
+	list := func() []string {
+		select {
+		case current = <-stations:
+		default:
+		}
+		return current
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runPrefetcher(ctx, list)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	expect := func(want string) {
+		t.Helper()
+		select {
+		case got := <-fetched:
+			if got != want {
+				t.Errorf("expected prefetch of %s, got %s", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for prefetch of %s", want)
+		}
+	}
+
+	expect("fip")
+	stations <- []string{"fip", "fip_new"}
+	expect("fip_new")
+}
+
+func TestStreamURLs(t *testing.T) {
+	streams := streamURLs("fip_rock", stationConfig{ID: 64})
+	if len(streams) != 5 {
+		t.Fatalf("expected 5 streams, got %+v", streams)
+	}
+	if streams[0].Format != "hls" || streams[0].URL != "https://stream.radiofrance.fr/fiprock/fiprock.m3u8?id=radiofrance" {
+		t.Errorf("unexpected HLS stream: %+v", streams[0])
+	}
+	if streams[1].Bitrate != 192 || streams[1].URL != "https://icecast.radiofrance.fr/fiprock-hifi.aac" {
+		t.Errorf("unexpected AAC stream: %+v", streams[1])
+	}
+
+	streams = streamURLs("fip", stationConfig{StreamSlug: "fip-main"})
+	if streams[3].URL != "https://icecast.radiofrance.fr/fip-main-midfi.mp3" {
+		t.Errorf("expected stream slug override, got %+v", streams[3])
+	}
+}
+
+func TestSummarizeTrack(t *testing.T) {
+	data := []byte(`{"now":{"firstLine":{"title":"Song"},"secondLine":{"title":"Artist"},
+		"visuals":{"card":{"src":"https://example.com/cover.jpg"}},"songUuid":"abc","startTime":100,"endTime":300}}`)
+	summary := summarizeTrack(data)
+	want := trackSummary{Title: "Song", Artist: "Artist", Cover: "https://example.com/cover.jpg", SongUUID: "abc", StartTime: 100, EndTime: 300}
+	if summary == nil || *summary != want {
+		t.Errorf("summarizeTrack() = %+v, want %+v", summary, want)
+	}
+
+	if summarizeTrack([]byte(`{"stationName":"fip"}`)) != nil {
+		t.Error("expected nil summary without a current track")
+	}
+}
+
+func TestStationHandler(t *testing.T) {
+	originalFetchMetadata := fetchMetadata
+	defer func() { fetchMetadata = originalFetchMetadata }()
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		return []byte(`{"now":{"firstLine":{"title":"Song"},"secondLine":{"title":"Artist"}}}`), nil
+	}
+	cache.Purge()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/stations/{param}", stationHandler)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stations/fip_nouveautes", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var entry stationEntry
+	if err := json.Unmarshal(rr.Body.Bytes(), &entry); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if entry.DisplayName != "Tout nouveau, tout FIP" || len(entry.Streams) == 0 {
+		t.Errorf("unexpected station entry: %+v", entry)
+	}
+	if entry.Now == nil || entry.Now.Title != "Song" || entry.Now.Artist != "Artist" {
+		t.Errorf("expected current track, got %+v", entry.Now)
+	}
+
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stations/fip_nonexistent", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown station, got %d", rr.Code)
+	}
+}
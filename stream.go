@@ -0,0 +1,245 @@
+// ABOUTME: Server-Sent Events stream of track changes per station.
+// ABOUTME: One shared poller per station fans out updates to every subscribed client.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	streamHeartbeat = 15 * time.Second // Keep-alive comment interval for idle streams
+
+	streams = newStreamHub()
+)
+
+// streamHub owns one poller per station that has at least one subscriber
+type streamHub struct {
+	mu      sync.Mutex
+	pollers map[string]*stationPoller
+	running sync.WaitGroup
+}
+
+func newStreamHub() *streamHub {
+	return &streamHub{pollers: make(map[string]*stationPoller)}
+}
+
+// Subscribe registers interest in a station's track changes. The channel always holds the
+// latest payload only, so a slow reader skips intermediate tracks rather than blocking the poller.
+// The returned function must be called to unsubscribe.
+func (h *streamHub) Subscribe(station string) (<-chan []byte, func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.pollers[station]
+	if !ok {
+		ctx, cancel := context.WithCancel(serverCtx)
+		p = &stationPoller{
+			station:     station,
+			ctx:         ctx,
+			cancel:      cancel,
+			subscribers: make(map[chan []byte]struct{}),
+		}
+		h.pollers[station] = p
+		h.running.Add(1)
+		go func() {
+			defer h.running.Done()
+			p.run()
+		}()
+	}
+
+	ch := make(chan []byte, 1)
+	p.add(ch)
+	streamSubscribers.WithLabelValues(station).Inc()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() { h.unsubscribe(p, ch) })
+	}
+}
+
+func (h *streamHub) unsubscribe(p *stationPoller, ch chan []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	streamSubscribers.WithLabelValues(p.station).Dec()
+	if p.remove(ch) == 0 {
+		delete(h.pollers, p.station)
+		p.cancel()
+	}
+}
+
+// Wait blocks until every poller has stopped; a poller stops once its last subscriber leaves
+func (h *streamHub) Wait() {
+	h.running.Wait()
+}
+
+// Subscribers returns the number of subscribers for a station
+func (h *streamHub) Subscribers(station string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	p, ok := h.pollers[station]
+	if !ok {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subscribers)
+}
+
+// stationPoller polls one station and broadcasts whenever now.songUuid changes.
+// It runs until its last subscriber leaves or the server shuts down.
+type stationPoller struct {
+	station string
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	mu          sync.Mutex
+	subscribers map[chan []byte]struct{}
+	last        []byte
+	lastSong    string
+}
+
+func (p *stationPoller) add(ch chan []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.subscribers[ch] = struct{}{}
+	// Late subscribers get the current track straight away
+	if p.last != nil {
+		deliverLatest(ch, p.last)
+	}
+}
+
+func (p *stationPoller) remove(ch chan []byte) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.subscribers, ch)
+	return len(p.subscribers)
+}
+
+func (p *stationPoller) run() {
+	slog.Debug("starting stream poller", "station", p.station)
+	defer slog.Debug("stopped stream poller", "station", p.station)
+
+	for {
+		delay := p.poll()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-p.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// poll fetches the station once, broadcasts on change, and returns how long to wait before the next poll
+func (p *stationPoller) poll() time.Duration {
+	data, _, err := getCachedData(p.ctx, p.station)
+	if err != nil {
+		slog.Warn("error polling station for stream", "station", p.station, "error", err)
+		return minPollInterval
+	}
+
+	summary, err := summarizePayload(data)
+	if err != nil {
+		slog.Warn("error polling station for stream", "station", p.station, "error", err)
+		return minPollInterval
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last == nil || summary.Now.SongUUID != p.lastSong {
+		p.last = data
+		p.lastSong = summary.Now.SongUUID
+		for ch := range p.subscribers {
+			deliverLatest(ch, data)
+		}
+	}
+
+	return refreshDelay(summary, time.Now())
+}
+
+// deliverLatest sends data on a single-slot channel, replacing any unread value
+func deliverLatest(ch chan []byte, data []byte) {
+	select {
+	case ch <- data:
+		return
+	default:
+	}
+	select {
+	case <-ch:
+	default:
+	}
+	select {
+	case ch <- data:
+	default:
+	}
+}
+
+// streamHandler serves GET /api/stream/{param} as a Server-Sent Events stream.
+// Each "track" event carries the same payload as /api/metadata/{param}.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	station := mux.Vars(r)["param"]
+	if _, ok := registry.Lookup(station); !ok {
+		writeError(w, r, &unknownStationError{Station: station})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeProblem(w, http.StatusInternalServerError, "Streaming unsupported", "response writer does not support flushing")
+		return
+	}
+
+	updates, unsubscribe := streams.Subscribe(station)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	setCORSHeaders(w)
+	w.WriteHeader(http.StatusOK)
+
+	// Ask EventSource clients to reconnect quickly after a dropped connection
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", minPollInterval.Milliseconds()); err != nil {
+		return
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-drainCtx.Done():
+			// Shutting down; EventSource clients reconnect to another instance after retry
+			return
+		case data := <-updates:
+			summary, _ := summarizePayload(data)
+			if _, err := fmt.Fprintf(w, "event: track\nid: %s\ndata: %s\n\n", summary.Now.SongUUID, data); err != nil {
+				slog.DebugContext(r.Context(), "error writing stream event", "station", station, "error", err)
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
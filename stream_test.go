@@ -0,0 +1,172 @@
+// ABOUTME: Unit tests for the Server-Sent Events stream and shared station pollers.
+// ABOUTME: Stubs fetchMetadata with a scripted sequence of tracks.
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// stubTrackSequence replaces fetchMetadata with one returning the current song UUID,
+// clears and disables caching, and shortens poll intervals for the duration of the test.
+func stubTrackSequence(t *testing.T) (setSong func(string)) {
+	t.Helper()
+
+	originalFetchMetadata := fetchMetadata
+	originalMinTTL, originalMaxTTL, originalSWR := cacheMinTTL, cacheMaxTTL, staleWhileRevalidate
+	originalMin, originalMax := minPollInterval, maxPollInterval
+	t.Cleanup(func() {
+		fetchMetadata = originalFetchMetadata
+		cacheMinTTL, cacheMaxTTL, staleWhileRevalidate = originalMinTTL, originalMaxTTL, originalSWR
+		minPollInterval, maxPollInterval = originalMin, originalMax
+	})
+
+	cache.Purge()
+
+	cacheMinTTL, cacheMaxTTL, staleWhileRevalidate = 0, 0, 0
+	minPollInterval = 10 * time.Millisecond
+	maxPollInterval = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	song := "song-1"
+	fetchMetadata = func(ctx context.Context, param string) ([]byte, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return []byte(fmt.Sprintf(`{"stationName":%q,"delayToRefresh":1,"now":{"songUuid":%q}}`, param, song)), nil
+	}
+
+	return func(s string) {
+		mu.Lock()
+		song = s
+		mu.Unlock()
+	}
+}
+
+func receive(t *testing.T, ch <-chan []byte) string {
+	t.Helper()
+	select {
+	case data := <-ch:
+		return string(data)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for stream update")
+		return ""
+	}
+}
+
+func TestStreamHubBroadcastsOnlyOnChange(t *testing.T) {
+	setSong := stubTrackSequence(t)
+	hub := newStreamHub()
+	defer hub.Wait()
+
+	updates, unsubscribe := hub.Subscribe("fip")
+	defer unsubscribe()
+
+	if got := receive(t, updates); !strings.Contains(got, "song-1") {
+		t.Fatalf("expected initial update for song-1, got %s", got)
+	}
+
+	// Several polls with the same song must not produce an update
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case data := <-updates:
+		t.Fatalf("unexpected update without song change: %s", data)
+	default:
+	}
+
+	setSong("song-2")
+	if got := receive(t, updates); !strings.Contains(got, "song-2") {
+		t.Fatalf("expected update for song-2, got %s", got)
+	}
+}
+
+func TestStreamHubSharesPollerPerStation(t *testing.T) {
+	stubTrackSequence(t)
+	hub := newStreamHub()
+	defer hub.Wait()
+
+	first, unsubscribeFirst := hub.Subscribe("fip_jazz")
+	receive(t, first)
+	second, unsubscribeSecond := hub.Subscribe("fip_jazz")
+
+	// The late subscriber gets the current track without waiting for a change
+	if got := receive(t, second); !strings.Contains(got, "song-1") {
+		t.Fatalf("expected late subscriber to receive current track, got %s", got)
+	}
+	if n := hub.Subscribers("fip_jazz"); n != 2 {
+		t.Errorf("expected 2 subscribers on one poller, got %d", n)
+	}
+
+	unsubscribeFirst()
+	unsubscribeSecond()
+	unsubscribeSecond() // idempotent
+	if n := hub.Subscribers("fip_jazz"); n != 0 {
+		t.Errorf("expected poller to be removed after last unsubscribe, got %d subscribers", n)
+	}
+}
+
+func TestStreamHandler(t *testing.T) {
+	stubTrackSequence(t)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/stream/{param}", streamHandler)
+	server := httptest.NewServer(router)
+
+	resp, err := http.Get(server.URL + "/api/stream/fip_rock")
+	if err != nil {
+		t.Fatalf("error opening stream: %v", err)
+	}
+	// Disconnecting must stop the shared poller before the stubs are restored
+	defer streams.Wait()
+	defer server.Close()
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("expected text/event-stream content type, got %s", ct)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	var event []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("error reading stream: %v", err)
+		}
+		line = strings.TrimRight(line, "\n")
+		if line == "" && len(event) > 0 && strings.HasPrefix(event[0], "event:") {
+			break
+		}
+		if line == "" {
+			event = nil
+			continue
+		}
+		event = append(event, line)
+	}
+
+	if event[0] != "event: track" || event[1] != "id: song-1" || !strings.HasPrefix(event[2], "data: {") {
+		t.Errorf("unexpected event: %q", event)
+	}
+}
+
+func TestStreamHandlerUnknownStation(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/stream/{param}", streamHandler)
+
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/api/stream/fip_nonexistent", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown station, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,72 @@
+// ABOUTME: Server-side timing of the current track: elapsed, remaining and when to poll next.
+// ABOUTME: Also sets Cache-Control and Expires so HTTP caches drop metadata with its cache entry.
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+)
+
+// trackTiming is where the current track is by the server's clock, so clients with skewed
+// clocks need not compute it themselves
+type trackTiming struct {
+	ServerTime time.Time `json:"serverTime"`
+	Elapsed    *float64  `json:"elapsed,omitempty"`   // seconds since the track started
+	Remaining  *float64  `json:"remaining,omitempty"` // seconds until it ends
+	Percent    *float64  `json:"percent,omitempty"`   // 0 to 100
+	NextPollAt time.Time `json:"nextPollAt"`          // when the server will next have fresh data
+
+	endsAt time.Time // end of the current track; zero when unknown
+}
+
+// newTrackTiming computes the timing of a v1 payload fetched at fetchedAt. nextPollAt follows
+// the prefetcher's schedule (see refreshDelay), so polling then finds the refreshed entry.
+func newTrackTiming(data []byte, fetchedAt, now time.Time) trackTiming {
+	timing := trackTiming{ServerTime: now.UTC()}
+	summary, err := summarizePayload(data)
+	if err != nil {
+		timing.NextPollAt = now.Add(minPollInterval).UTC()
+		return timing
+	}
+
+	track := &trackSummary{StartTime: int64(summary.Now.StartTime), EndTime: int64(summary.Now.EndTime)}
+	if progress, ok := trackProgress(track, now); ok {
+		duration := float64(track.EndTime - track.StartTime)
+		elapsed, remaining, percent := roundTenth(progress*duration), roundTenth((1-progress)*duration), roundTenth(progress*100)
+		timing.Elapsed, timing.Remaining, timing.Percent = &elapsed, &remaining, &percent
+		timing.endsAt = time.Unix(track.EndTime, 0)
+	}
+
+	nextPoll := fetchedAt.Add(refreshDelay(summary, fetchedAt))
+	if nextPoll.Before(now) {
+		nextPoll = now.Add(minPollInterval)
+	}
+	timing.NextPollAt = nextPoll.UTC()
+	return timing
+}
+
+// cacheExpiry is when HTTP caches should drop the response: when its cache entry expires,
+// or when the current track ends if that is sooner
+func (t trackTiming) cacheExpiry(entryExpiresAt time.Time) time.Time {
+	if !t.endsAt.IsZero() && t.endsAt.Before(entryExpiresAt) {
+		return t.endsAt
+	}
+	return entryExpiresAt
+}
+
+// setCacheHeaders lets HTTP caches keep the response until expiresAt, in whole seconds so
+// it never outlives it, and makes them revalidate once it has passed
+func setCacheHeaders(w http.ResponseWriter, expiresAt, now time.Time) {
+	w.Header().Set("Expires", expiresAt.UTC().Format(http.TimeFormat))
+	if maxAge := expiresAt.Sub(now).Truncate(time.Second); maxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+}
+
+func roundTenth(v float64) float64 {
+	return math.Round(v*10) / 10
+}
@@ -0,0 +1,151 @@
+// ABOUTME: Unit tests for the server-side track timing and Cache-Control headers.
+// ABOUTME: Uses payloads timed relative to the test's clock so max-age can be checked.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func TestNewTrackTiming(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	payload := `{"delayToRefresh": 60000, "now": {"startTime": 1700000000, "endTime": 1700000200}}`
+
+	tests := []struct {
+		name          string
+		data          string
+		fetchedAt     time.Time
+		now           time.Time
+		wantElapsed   float64
+		wantRemaining float64
+		wantPercent   float64
+		wantNextPoll  time.Time
+	}{
+		// delayToRefresh is sooner than the track end
+		{"first quarter", payload, start, start.Add(50 * time.Second), 50, 150, 25, start.Add(time.Minute)},
+		// The track end is sooner than delayToRefresh
+		{"last minute", payload, start.Add(170 * time.Second), start.Add(180 * time.Second), 180, 20, 90, start.Add(200 * time.Second)},
+		// The scheduled refresh has passed, so poll again soon
+		{"overrun", payload, start.Add(150 * time.Second), start.Add(300 * time.Second), 200, 0, 100, start.Add(305 * time.Second)},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			timing := newTrackTiming([]byte(tc.data), tc.fetchedAt, tc.now)
+			if timing.Elapsed == nil || timing.Remaining == nil || timing.Percent == nil {
+				t.Fatalf("expected elapsed, remaining and percent, got %+v", timing)
+			}
+			if *timing.Elapsed != tc.wantElapsed || *timing.Remaining != tc.wantRemaining || *timing.Percent != tc.wantPercent {
+				t.Errorf("got elapsed %v remaining %v percent %v, want %v %v %v",
+					*timing.Elapsed, *timing.Remaining, *timing.Percent, tc.wantElapsed, tc.wantRemaining, tc.wantPercent)
+			}
+			if !timing.ServerTime.Equal(tc.now) || !timing.NextPollAt.Equal(tc.wantNextPoll) {
+				t.Errorf("got serverTime %v nextPollAt %v, want %v %v", timing.ServerTime, timing.NextPollAt, tc.now, tc.wantNextPoll)
+			}
+			// HTTP caches drop the response at the track end or with the entry, whichever is sooner
+			end := start.Add(200 * time.Second)
+			if got := timing.cacheExpiry(start.Add(time.Hour)); !got.Equal(end) {
+				t.Errorf("cacheExpiry() = %v, want the track end %v", got, end)
+			}
+			if got := timing.cacheExpiry(start.Add(time.Second)); !got.Equal(start.Add(time.Second)) {
+				t.Errorf("cacheExpiry() = %v, want the entry expiry", got)
+			}
+		})
+	}
+
+	// Without track times only the server time and next poll are known
+	timing := newTrackTiming([]byte(`{"delayToRefresh": 30000}`), start, start)
+	if timing.Elapsed != nil || timing.Remaining != nil || timing.Percent != nil || !timing.cacheExpiry(start).Equal(start) {
+		t.Errorf("expected no progress without track times, got %+v", timing)
+	}
+	if !timing.NextPollAt.Equal(start.Add(30 * time.Second)) {
+		t.Errorf("expected next poll after delayToRefresh, got %v", timing.NextPollAt)
+	}
+}
+
+func TestMetadataCacheControl(t *testing.T) {
+	now := time.Now()
+	stubLivemeta(t, fmt.Sprintf(`{
+		"now": {"firstLine": "So What", "secondLine": "Miles Davis", "startTime": %d, "endTime": %d},
+		"delayToRefresh": 60000
+	}`, now.Add(-time.Minute).Unix(), now.Add(3*time.Minute).Unix()))
+
+	maxAge := func(rr *httptest.ResponseRecorder) int {
+		t.Helper()
+		var seconds int
+		if _, err := fmt.Sscanf(rr.Header().Get("Cache-Control"), "public, max-age=%d", &seconds); err != nil {
+			t.Fatalf("unexpected Cache-Control %q", rr.Header().Get("Cache-Control"))
+		}
+		return seconds
+	}
+
+	// Entries last until delayToRefresh, which comes before the track ends
+	checkExpiry := func(rr *httptest.ResponseRecorder) {
+		t.Helper()
+		if age := maxAge(rr); age < 55 || age > 60 {
+			t.Errorf("expected max-age until delayToRefresh, got %d", age)
+		}
+		expires, err := http.ParseTime(rr.Header().Get("Expires"))
+		if err != nil {
+			t.Fatalf("unexpected Expires %q: %v", rr.Header().Get("Expires"), err)
+		}
+		if wait := expires.Sub(now); wait < 55*time.Second || wait > 61*time.Second {
+			t.Errorf("expected Expires after delayToRefresh, got %v", expires)
+		}
+	}
+
+	v1 := serveAPI("GET", "/api/v1/metadata/fip")
+	checkExpiry(v1)
+	if strings.Contains(v1.Body.String(), "timing") {
+		t.Errorf("expected the v1 shape to be unchanged, got %s", v1.Body.String())
+	}
+
+	v2 := serveAPI("GET", "/api/v2/metadata/fip")
+	checkExpiry(v2)
+	var resp struct {
+		Timing struct {
+			ServerTime time.Time `json:"serverTime"`
+			Elapsed    float64   `json:"elapsed"`
+			Remaining  float64   `json:"remaining"`
+			Percent    float64   `json:"percent"`
+			NextPollAt time.Time `json:"nextPollAt"`
+		} `json:"timing"`
+	}
+	if err := json.Unmarshal(v2.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	timing := resp.Timing
+	if timing.ServerTime.IsZero() || timing.Elapsed < 59 || timing.Remaining > 181 || timing.Percent < 24 || timing.Percent > 26 {
+		t.Errorf("unexpected timing %+v", timing)
+	}
+	if wait := timing.NextPollAt.Sub(now); wait < 55*time.Second || wait > 65*time.Second {
+		t.Errorf("expected next poll after delayToRefresh, got %v", timing.NextPollAt)
+	}
+
+	// The timing differs between requests but the weak ETag does not
+	etag := v2.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("expected a weak ETag, got %q", etag)
+	}
+	router := mux.NewRouter()
+	registerAPI(router)
+	req := httptest.NewRequest("GET", "/api/v2/metadata/fip", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotModified || rr.Header().Get("Cache-Control") == "" {
+		t.Errorf("expected 304 with Cache-Control, got %d %v", rr.Code, rr.Header())
+	}
+
+	// Once the track has ended HTTP caches must revalidate, even though the entry is fresh
+	stubLivemeta(t, versionedPayload)
+	if cc := serveAPI("GET", "/api/v1/metadata/fip").Header().Get("Cache-Control"); cc != "no-cache" {
+		t.Errorf("expected no-cache past the track end, got %q", cc)
+	}
+}
@@ -0,0 +1,329 @@
+// ABOUTME: Shared HTTP client for Radio France with timeouts, jittered retries and per-station circuit breakers.
+// ABOUTME: Breaker state is reported by GET /admin/breakers.
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	upstreamConnectTimeout = 5 * time.Second        // Dial and TLS handshake timeout
+	upstreamReadTimeout    = 5 * time.Second        // Wait for response headers after sending the request
+	upstreamRetries        = 2                      // Extra attempts after a 5xx or network error
+	upstreamRetryBaseDelay = 200 * time.Millisecond // Delay before the first retry, doubled for each further one
+	upstreamRetryMaxDelay  = 2 * time.Second        // Cap on the delay between retries
+	breakerThreshold       = 5                      // Consecutive failed fetches that open a station's breaker
+	breakerCooldown        = 30 * time.Second       // How long an open breaker rejects calls before a probe
+
+	// upstream is the client every Radio France call goes through. applyConfig rebuilds it.
+	upstream = newUpstreamClient()
+)
+
+// upstreamClient sends requests to Radio France. One HTTP client and connection pool is shared
+// by every station; failures are tracked per station.
+type upstreamClient struct {
+	client         *http.Client
+	retries        int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	threshold      int
+	cooldown       time.Duration
+
+	lastSuccess atomic.Int64 // Unix nanoseconds of the last successful call, for readiness
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+// newUpstreamClient builds a client from the current upstream settings
+func newUpstreamClient() *upstreamClient {
+	dialer := &net.Dialer{Timeout: upstreamConnectTimeout, KeepAlive: 30 * time.Second}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   upstreamConnectTimeout,
+		ResponseHeaderTimeout: upstreamReadTimeout,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &upstreamClient{
+		client:         &http.Client{Timeout: upstreamTimeout, Transport: transport},
+		retries:        upstreamRetries,
+		retryBaseDelay: upstreamRetryBaseDelay,
+		retryMaxDelay:  upstreamRetryMaxDelay,
+		threshold:      breakerThreshold,
+		cooldown:       breakerCooldown,
+		breakers:       make(map[string]*circuitBreaker),
+	}
+}
+
+// Fetch GETs url on behalf of station and returns the decompressed body of a 200 response.
+// 5xx responses and network errors are retried with jittered exponential backoff; the
+// outcome after retries is recorded on the station's circuit breaker. Cancelling ctx aborts
+// the call without counting against the breaker.
+func (u *upstreamClient) Fetch(ctx context.Context, station, url string) ([]byte, error) {
+	breaker := u.breaker(station)
+	if err := breaker.Allow(); err != nil {
+		return nil, &upstreamError{Station: station, Kind: upstreamKindCircuitOpen, Err: err}
+	}
+
+	var err error
+	for attempt := 0; attempt <= u.retries; attempt++ {
+		if attempt > 0 {
+			delay := u.retryDelay(attempt)
+			slog.WarnContext(ctx, "retrying upstream request", "station", station, "delay", delay, "error", err)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				breaker.Abandon()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+
+		var data []byte
+		data, err = u.fetchOnce(ctx, station, url)
+		if err == nil {
+			breaker.Success()
+			u.lastSuccess.Store(time.Now().UnixNano())
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			breaker.Abandon()
+			return nil, ctx.Err()
+		}
+		if !retryable(err) {
+			break
+		}
+	}
+
+	breaker.Failure()
+	return nil, err
+}
+
+func (u *upstreamClient) fetchOnce(ctx context.Context, station, url string) (data []byte, err error) {
+	stationID := stationIDLabel(station)
+	start := time.Now()
+	code := "error"
+	defer func() {
+		elapsed := time.Since(start)
+		upstreamDuration.WithLabelValues(stationID).Observe(elapsed.Seconds())
+		upstreamRequests.WithLabelValues(stationID, code).Inc()
+		slog.DebugContext(ctx, "upstream request", "station", station, "url", url, "code", code, "latency", elapsed)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request for %s: %v", station, err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip, deflate")
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		transportErr := newTransportError(station, err)
+		if transportErr.Kind == upstreamKindTimeout {
+			code = "timeout"
+		}
+		return nil, transportErr
+	}
+	defer resp.Body.Close()
+	code = strconv.Itoa(resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &upstreamError{Station: station, Kind: upstreamKindStatus, StatusCode: resp.StatusCode}
+	}
+
+	// Handle gzip-compressed responses
+	var reader io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzReader, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, &upstreamError{Station: station, Kind: upstreamKindMalformed, Err: fmt.Errorf("error creating gzip reader: %v", err)}
+		}
+		defer gzReader.Close()
+		reader = gzReader
+	}
+
+	data, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, newTransportError(station, fmt.Errorf("error reading response body: %w", err))
+	}
+	return data, nil
+}
+
+// retryable reports whether a failed attempt is worth repeating: network errors, timeouts and 5xx
+func retryable(err error) bool {
+	upstreamErr, ok := err.(*upstreamError)
+	if !ok {
+		return false
+	}
+	switch upstreamErr.Kind {
+	case upstreamKindUnavailable, upstreamKindTimeout:
+		return true
+	case upstreamKindStatus:
+		return upstreamErr.StatusCode >= 500
+	}
+	return false
+}
+
+// retryDelay picks a delay in [d/2, d] where d doubles with each attempt up to retryMaxDelay
+func (u *upstreamClient) retryDelay(attempt int) time.Duration {
+	d := u.retryBaseDelay << (attempt - 1)
+	if d > u.retryMaxDelay || d <= 0 {
+		d = u.retryMaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// LastSuccess returns when any station was last fetched successfully; zero if never
+func (u *upstreamClient) LastSuccess() time.Time {
+	if ns := u.lastSuccess.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+func (u *upstreamClient) breaker(station string) *circuitBreaker {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	b, ok := u.breakers[station]
+	if !ok {
+		b = &circuitBreaker{threshold: u.threshold, cooldown: u.cooldown, now: time.Now}
+		u.breakers[station] = b
+	}
+	return b
+}
+
+// Breakers returns the state of every station's breaker, sorted by station
+func (u *upstreamClient) Breakers() []breakerStatus {
+	u.mu.Lock()
+	statuses := make([]breakerStatus, 0, len(u.breakers))
+	for station, b := range u.breakers {
+		status := b.Status()
+		status.Station = station
+		statuses = append(statuses, status)
+	}
+	u.mu.Unlock()
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Station < statuses[j].Station })
+	return statuses
+}
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// circuitBreaker stops calling a failing station. It opens after threshold consecutive failures,
+// rejects calls for cooldown, then lets a single probe through: success closes it, failure reopens it.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// breakerStatus is a breaker as reported by /admin/breakers
+type breakerStatus struct {
+	Station  string     `json:"station"`
+	State    string     `json:"state"`
+	Failures int        `json:"failures"`
+	OpenedAt *time.Time `json:"openedAt,omitempty"`
+	RetryAt  *time.Time `json:"retryAt,omitempty"`
+}
+
+// Allow reports whether a call may proceed
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case breakerOpen:
+		return fmt.Errorf("circuit open until %s", b.openedAt.Add(b.cooldown).UTC().Format(time.RFC3339))
+	case breakerHalfOpen:
+		if b.probing {
+			return fmt.Errorf("circuit half-open, probe in flight")
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Success records a successful call and closes the breaker
+func (b *circuitBreaker) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state, b.failures, b.probing = breakerClosed, 0, false
+}
+
+// Abandon releases a half-open probe that was cancelled before it completed
+func (b *circuitBreaker) Abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// Failure records a failed call, opening the breaker at the threshold or after a failed probe
+func (b *circuitBreaker) Failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if b.currentState() == breakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt, b.probing = breakerOpen, b.now(), false
+	}
+}
+
+// Status returns the breaker's current state
+func (b *circuitBreaker) Status() breakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := breakerStatus{State: b.currentState(), Failures: b.failures}
+	if b.state == breakerOpen {
+		openedAt, retryAt := b.openedAt.UTC(), b.openedAt.Add(b.cooldown).UTC()
+		status.OpenedAt, status.RetryAt = &openedAt, &retryAt
+	}
+	return status
+}
+
+// currentState resolves an open breaker whose cooldown has passed to half-open. Callers hold mu.
+func (b *circuitBreaker) currentState() string {
+	if b.state == breakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		return breakerHalfOpen
+	}
+	if b.state == "" {
+		return breakerClosed
+	}
+	return b.state
+}
+
+// breakersHandler serves GET /admin/breakers
+func breakersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"breakers": upstream.Breakers(),
+	})
+}
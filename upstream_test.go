@@ -0,0 +1,184 @@
+// ABOUTME: Unit tests for the upstream client's retries and per-station circuit breakers.
+// ABOUTME: Uses httptest servers and a fake clock for the breaker.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testUpstreamClient builds a client with fast retries and restores the settings afterwards
+func testUpstreamClient(t *testing.T, retries, threshold int) *upstreamClient {
+	t.Helper()
+	originalRetries, originalBase, originalMax := upstreamRetries, upstreamRetryBaseDelay, upstreamRetryMaxDelay
+	originalThreshold, originalTimeout := breakerThreshold, upstreamTimeout
+	t.Cleanup(func() {
+		upstreamRetries, upstreamRetryBaseDelay, upstreamRetryMaxDelay = originalRetries, originalBase, originalMax
+		breakerThreshold, upstreamTimeout = originalThreshold, originalTimeout
+	})
+	upstreamRetries, upstreamRetryBaseDelay, upstreamRetryMaxDelay = retries, time.Millisecond, 4*time.Millisecond
+	breakerThreshold, upstreamTimeout = threshold, 100*time.Millisecond
+	return newUpstreamClient()
+}
+
+func TestUpstreamFetchRetries(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&calls, 1) < 3 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	client := testUpstreamClient(t, 2, 5)
+	data, err := client.Fetch(context.Background(), "fip", server.URL)
+	if err != nil {
+		t.Fatalf("expected success after retries, got %v", err)
+	}
+	if string(data) != `{"ok":true}` || atomic.LoadInt64(&calls) != 3 {
+		t.Errorf("unexpected result %s after %d calls", data, calls)
+	}
+	if status := client.Breakers()[0]; status.State != breakerClosed || status.Failures != 0 {
+		t.Errorf("expected closed breaker after success, got %+v", status)
+	}
+}
+
+func TestUpstreamFetchDoesNotRetryClientErrors(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	_, err := testUpstreamClient(t, 2, 5).Fetch(context.Background(), "fip", server.URL)
+	var upstreamErr *upstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr.Kind != upstreamKindStatus || upstreamErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 status error, got %v", err)
+	}
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Errorf("expected a single attempt for a 404, got %d", n)
+	}
+}
+
+func TestUpstreamFetchReadTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	_, err := testUpstreamClient(t, 0, 5).Fetch(context.Background(), "fip", server.URL)
+	var upstreamErr *upstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr.Kind != upstreamKindTimeout {
+		t.Errorf("expected a timeout error, got %v", err)
+	}
+}
+
+func TestUpstreamBreakerOpens(t *testing.T) {
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		http.Error(w, "boom", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := testUpstreamClient(t, 0, 2)
+	for i := 0; i < 2; i++ {
+		client.Fetch(context.Background(), "fip_rock", server.URL)
+	}
+	_, err := client.Fetch(context.Background(), "fip_rock", server.URL)
+	var upstreamErr *upstreamError
+	if !errors.As(err, &upstreamErr) || upstreamErr.Kind != upstreamKindCircuitOpen {
+		t.Fatalf("expected circuit open error, got %v", err)
+	}
+	if n := atomic.LoadInt64(&calls); n != 2 {
+		t.Errorf("expected no call while the breaker is open, got %d calls", n)
+	}
+	if p := problemFor(err); p.Status != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 for an open circuit, got %d", p.Status)
+	}
+
+	// Other stations are unaffected
+	if _, err := client.Fetch(context.Background(), "fip_jazz", server.URL); errors.As(err, &upstreamErr) && upstreamErr.Kind == upstreamKindCircuitOpen {
+		t.Error("expected fip_jazz breaker to be closed")
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := &circuitBreaker{threshold: 1, cooldown: 30 * time.Second, now: func() time.Time { return now }}
+
+	b.Failure()
+	if b.Allow() == nil {
+		t.Fatal("expected open breaker to reject calls")
+	}
+
+	now = now.Add(30 * time.Second)
+	if status := b.Status(); status.State != breakerHalfOpen {
+		t.Errorf("expected half-open after cooldown, got %s", status.State)
+	}
+	if err := b.Allow(); err != nil {
+		t.Fatalf("expected a probe to be allowed, got %v", err)
+	}
+	if b.Allow() == nil {
+		t.Error("expected only one probe while half-open")
+	}
+
+	b.Failure()
+	if status := b.Status(); status.State != breakerOpen || !status.OpenedAt.Equal(now) {
+		t.Errorf("expected failed probe to reopen the breaker, got %+v", status)
+	}
+
+	now = now.Add(30 * time.Second)
+	b.Allow()
+	b.Success()
+	if status := b.Status(); status.State != breakerClosed || status.Failures != 0 {
+		t.Errorf("expected successful probe to close the breaker, got %+v", status)
+	}
+}
+
+func TestBreakersHandler(t *testing.T) {
+	originalUpstream := upstream
+	defer func() { upstream = originalUpstream }()
+	upstream = testUpstreamClient(t, 0, 1)
+	upstream.breaker("fip_metal").Failure()
+
+	rr := httptest.NewRecorder()
+	breakersHandler(rr, httptest.NewRequest("GET", "/admin/breakers", nil))
+
+	var resp struct {
+		Breakers []breakerStatus `json:"breakers"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to unmarshal response: %v", err)
+	}
+	if len(resp.Breakers) != 1 || resp.Breakers[0].Station != "fip_metal" || resp.Breakers[0].State != breakerOpen || resp.Breakers[0].RetryAt == nil {
+		t.Errorf("unexpected breakers: %+v", resp.Breakers)
+	}
+}
+
+func TestUpstreamFetchCancelledDoesNotTripBreaker(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := testUpstreamClient(t, 2, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	if _, err := client.Fetch(ctx, "fip", server.URL); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	if status := client.Breakers()[0]; status.State != breakerClosed || status.Failures != 0 {
+		t.Errorf("expected a cancelled fetch not to count as a failure, got %+v", status)
+	}
+}
@@ -0,0 +1,247 @@
+// ABOUTME: WebSocket endpoint multiplexing track updates for many stations over one connection.
+// ABOUTME: Clients subscribe/unsubscribe by station name and receive the /api/metadata payload on change.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	wsPingInterval   = 30 * time.Second // How often the server pings idle clients
+	wsPongWait       = 60 * time.Second // How long to wait for a pong before dropping the client
+	wsWriteWait      = 10 * time.Second // Deadline for a single frame write
+	wsSendBuffer     = 32               // Outbound frames queued per client before it is considered too slow
+	wsMaxMessageSize = int64(4096)      // Largest accepted client frame
+
+	upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		// The API is public and already served with Access-Control-Allow-Origin: *
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+
+	// webSockets counts open connections so shutdown can wait for them to close
+	webSockets sync.WaitGroup
+)
+
+// wsClientMessage is a frame sent by the client
+type wsClientMessage struct {
+	Type     string   `json:"type"` // "subscribe" or "unsubscribe"
+	Stations []string `json:"stations"`
+}
+
+// wsServerMessage is a frame sent to the client
+type wsServerMessage struct {
+	Type     string          `json:"type"` // "update", "subscribed", "unsubscribed" or "error"
+	Station  string          `json:"station,omitempty"`
+	Stations []string        `json:"stations,omitempty"`
+	Data     json.RawMessage `json:"data,omitempty"`
+	Message  string          `json:"message,omitempty"`
+}
+
+// wsClient is one WebSocket connection and its station subscriptions.
+// Only the write loop writes to conn; everything else goes through send.
+type wsClient struct {
+	conn   *websocket.Conn
+	remote string
+	send   chan wsServerMessage
+
+	// subscriptions is owned by the read loop
+	subscriptions map[string]func()
+
+	done      chan struct{}
+	closeOnce sync.Once
+	slow      bool
+}
+
+func newWSClient(conn *websocket.Conn) *wsClient {
+	return &wsClient{
+		conn:          conn,
+		remote:        conn.RemoteAddr().String(),
+		send:          make(chan wsServerMessage, wsSendBuffer),
+		subscriptions: make(map[string]func()),
+		done:          make(chan struct{}),
+	}
+}
+
+// enqueue queues a frame without blocking. A client whose queue is full is disconnected
+// rather than allowed to hold up the station pollers.
+func (c *wsClient) enqueue(msg wsServerMessage) {
+	select {
+	case <-c.done:
+	case c.send <- msg:
+	default:
+		slog.Warn("dropping slow WebSocket client", "remote", c.remote)
+		c.shutdown(true)
+	}
+}
+
+func (c *wsClient) shutdown(slow bool) {
+	c.closeOnce.Do(func() {
+		c.slow = slow
+		close(c.done)
+	})
+}
+
+func (c *wsClient) subscribe(station string) {
+	if _, ok := c.subscriptions[station]; ok {
+		return
+	}
+
+	updates, unsubscribe := streams.Subscribe(station)
+	stop := make(chan struct{})
+	c.subscriptions[station] = func() {
+		close(stop)
+		unsubscribe()
+	}
+
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-c.done:
+				return
+			case data := <-updates:
+				c.enqueue(wsServerMessage{Type: "update", Station: station, Data: data})
+			}
+		}
+	}()
+}
+
+func (c *wsClient) unsubscribe(station string) {
+	if cancel, ok := c.subscriptions[station]; ok {
+		cancel()
+		delete(c.subscriptions, station)
+	}
+}
+
+// handleMessage applies a subscribe/unsubscribe request. Unknown stations are rejected
+// individually; the remaining stations in the frame are still applied.
+func (c *wsClient) handleMessage(msg wsClientMessage) {
+	var applied []string
+	for _, station := range msg.Stations {
+		if _, ok := registry.Lookup(station); !ok {
+			c.enqueue(wsServerMessage{Type: "error", Station: station, Message: fmt.Sprintf("unknown station: %s", station)})
+			continue
+		}
+		switch msg.Type {
+		case "subscribe":
+			c.subscribe(station)
+		case "unsubscribe":
+			c.unsubscribe(station)
+		}
+		applied = append(applied, station)
+	}
+
+	if len(applied) > 0 {
+		c.enqueue(wsServerMessage{Type: msg.Type + "d", Stations: applied})
+	}
+}
+
+// readLoop processes client frames until the connection fails, then releases all subscriptions
+func (c *wsClient) readLoop() {
+	defer func() {
+		for station := range c.subscriptions {
+			c.unsubscribe(station)
+		}
+		c.shutdown(false)
+	}()
+
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	if err := c.conn.SetReadDeadline(time.Now().Add(wsPongWait)); err != nil {
+		return
+	}
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		var msg wsClientMessage
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Warn("WebSocket read error", "remote", c.remote, "error", err)
+			}
+			return
+		}
+
+		switch msg.Type {
+		case "subscribe", "unsubscribe":
+			c.handleMessage(msg)
+		default:
+			c.enqueue(wsServerMessage{Type: "error", Message: fmt.Sprintf("unknown message type: %q", msg.Type)})
+		}
+	}
+}
+
+// writeLoop drains the send queue and pings the client until the connection is shut down
+func (c *wsClient) writeLoop() {
+	ping := time.NewTicker(wsPingInterval)
+	defer func() {
+		ping.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case msg := <-c.send:
+			if err := c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait)); err != nil {
+				c.shutdown(false)
+				return
+			}
+			if err := c.conn.WriteJSON(msg); err != nil {
+				c.shutdown(false)
+				return
+			}
+		case <-ping.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+				c.shutdown(false)
+				return
+			}
+		case <-c.done:
+			code, reason := websocket.CloseNormalClosure, ""
+			if c.slow {
+				code, reason = websocket.ClosePolicyViolation, "client too slow"
+			} else if drainCtx.Err() != nil {
+				code, reason = websocket.CloseGoingAway, "server shutting down"
+			}
+			// Best effort; the peer may already be gone
+			_ = c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(wsWriteWait))
+			return
+		}
+	}
+}
+
+// wsHandler serves GET /ws. Clients send {"type":"subscribe","stations":[...]} or
+// {"type":"unsubscribe","stations":[...]} and receive {"type":"update","station":...,"data":...}
+// frames whenever a subscribed station changes track.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error response
+		slog.WarnContext(r.Context(), "WebSocket upgrade failed", "error", err)
+		return
+	}
+
+	// Hijacked connections are not tracked by http.Server.Shutdown, so they are counted here
+	webSockets.Add(1)
+	defer webSockets.Done()
+
+	client := newWSClient(conn)
+	go client.writeLoop()
+	go func() {
+		select {
+		case <-drainCtx.Done():
+			client.shutdown(false)
+		case <-client.done:
+		}
+	}()
+	client.readLoop()
+}
@@ -0,0 +1,131 @@
+// ABOUTME: Unit tests for the multiplexed WebSocket subscription endpoint.
+// ABOUTME: Drives a real connection against an httptest server with stubbed metadata.
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialTestWS(t *testing.T) (*websocket.Conn, func()) {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(wsHandler))
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		server.Close()
+		t.Fatalf("error dialing WebSocket: %v", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		server.Close()
+		// Closing the connection releases its subscriptions; wait for the pollers to stop
+		streams.Wait()
+	}
+}
+
+func readWSMessage(t *testing.T, conn *websocket.Conn) wsServerMessage {
+	t.Helper()
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	var msg wsServerMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("error reading WebSocket frame: %v", err)
+	}
+	return msg
+}
+
+func TestWSSubscribeMultipleStations(t *testing.T) {
+	stubTrackSequence(t)
+	conn, cleanup := dialTestWS(t)
+	defer cleanup()
+
+	if err := conn.WriteJSON(wsClientMessage{Type: "subscribe", Stations: []string{"fip", "fip_rock"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var updated []string
+	acked := false
+	for len(updated) < 2 || !acked {
+		msg := readWSMessage(t, conn)
+		switch msg.Type {
+		case "subscribed":
+			acked = true
+			if len(msg.Stations) != 2 {
+				t.Errorf("expected 2 stations acknowledged, got %v", msg.Stations)
+			}
+		case "update":
+			updated = append(updated, msg.Station)
+			if !strings.Contains(string(msg.Data), `"stationName":"`+msg.Station+`"`) {
+				t.Errorf("update data does not match station %s: %s", msg.Station, msg.Data)
+			}
+		default:
+			t.Fatalf("unexpected frame: %+v", msg)
+		}
+	}
+
+	sort.Strings(updated)
+	if updated[0] != "fip" || updated[1] != "fip_rock" {
+		t.Errorf("expected updates for fip and fip_rock, got %v", updated)
+	}
+
+	if err := conn.WriteJSON(wsClientMessage{Type: "unsubscribe", Stations: []string{"fip"}}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readWSMessage(t, conn); msg.Type != "unsubscribed" || msg.Stations[0] != "fip" {
+		t.Errorf("expected unsubscribed ack for fip, got %+v", msg)
+	}
+}
+
+func TestWSRejectsUnknownStation(t *testing.T) {
+	stubTrackSequence(t)
+	conn, cleanup := dialTestWS(t)
+	defer cleanup()
+
+	if err := conn.WriteJSON(wsClientMessage{Type: "subscribe", Stations: []string{"fip_nonexistent"}}); err != nil {
+		t.Fatal(err)
+	}
+	msg := readWSMessage(t, conn)
+	if msg.Type != "error" || msg.Station != "fip_nonexistent" {
+		t.Errorf("expected error frame for unknown station, got %+v", msg)
+	}
+
+	if err := conn.WriteJSON(wsClientMessage{Type: "dance"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readWSMessage(t, conn); msg.Type != "error" {
+		t.Errorf("expected error frame for unknown message type, got %+v", msg)
+	}
+}
+
+func TestWSClientEnqueueDropsSlowClient(t *testing.T) {
+	client := &wsClient{
+		send: make(chan wsServerMessage, 1),
+		done: make(chan struct{}),
+	}
+
+	client.enqueue(wsServerMessage{Type: "update"})
+	select {
+	case <-client.done:
+		t.Fatal("client shut down before its queue was full")
+	default:
+	}
+
+	client.enqueue(wsServerMessage{Type: "update"})
+	select {
+	case <-client.done:
+	default:
+		t.Fatal("expected client with a full queue to be shut down")
+	}
+	if !client.slow {
+		t.Error("expected client to be marked slow")
+	}
+}